@@ -0,0 +1,122 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+var awsAccountRgx = regexp.MustCompile(`^\d{12}$`)
+
+// isSecretARN reports whether the path is an ARN rather than a name.
+func isSecretARN(path string) bool {
+	return strings.HasPrefix(path, "arn:")
+}
+
+// parseSecretARN parses the ARN of a secret of AWS Secrets Manager.
+func parseSecretARN(s string) (arn.ARN, error) {
+	a, err := arn.Parse(s)
+	if err != nil {
+		return arn.ARN{}, err
+	}
+	if a.Service != "secretsmanager" || !strings.HasPrefix(a.Resource, "secret:") || a.Resource == "secret:" {
+		return arn.ARN{}, fmt.Errorf("not a secrets manager secret")
+	}
+	if !awsRegionRgx.MatchString(a.Region) || !awsAccountRgx.MatchString(a.AccountID) {
+		return arn.ARN{}, fmt.Errorf("malformed region or account")
+	}
+	return a, nil
+}
+
+// validateARNs validates the paths of the configuration being full ARNs. The
+// ARNs must be in the same region, which must not conflict with the
+// configured one, and in the expected account.
+func validateARNs(cfg *Config) error {
+	if cfg.ExpectedAccount != "" && !awsAccountRgx.MatchString(cfg.ExpectedAccount) {
+		return fmt.Errorf("secret %q has malformed %q expected account", cfg.ID, cfg.ExpectedAccount)
+	}
+	region := cfg.Region
+	if len(cfg.Regions) > 0 {
+		region = cfg.Regions[0]
+	}
+	for _, path := range cfg.getPaths() {
+		if !isSecretARN(path) {
+			continue
+		}
+		a, err := parseSecretARN(path)
+		if err != nil {
+			return fmt.Errorf("secret %q has malformed %q arn: %v", cfg.ID, path, err)
+		}
+		if region == "" {
+			region = a.Region
+		}
+		if a.Region != region {
+			return fmt.Errorf("secret %q has %q region, but its %q arn is in %q region", cfg.ID, region, path, a.Region)
+		}
+		if cfg.ExpectedAccount != "" && a.AccountID != cfg.ExpectedAccount {
+			return fmt.Errorf("secret %q has %q arn, but it is not in expected %q account", cfg.ID, path, cfg.ExpectedAccount)
+		}
+	}
+	return nil
+}
+
+// arnRegion returns the region of the ARN paths of the configuration.
+func (cfg *Config) arnRegion() string {
+	for _, path := range cfg.getPaths() {
+		if !isSecretARN(path) {
+			continue
+		}
+		if a, err := parseSecretARN(path); err == nil {
+			return a.Region
+		}
+	}
+	return ""
+}
+
+// regionalPath returns the path of the secret in the region. The ARN of a
+// replica differs from the ARN of the primary secret in the region only.
+func regionalPath(path, region string) string {
+	if !isSecretARN(path) {
+		return path
+	}
+	a, err := parseSecretARN(path)
+	if err != nil || a.Region == region {
+		return path
+	}
+	a.Region = region
+	return a.String()
+}
+
+// checkAccount returns an error when the fetched secret is not in the
+// expected account.
+func (p *Plugin) checkAccount(path string, output *secretsmanager.GetSecretValueOutput) error {
+	if p.Config.ExpectedAccount == "" {
+		return nil
+	}
+	a, err := arn.Parse(aws.ToString(output.ARN))
+	if err != nil {
+		return fmt.Errorf("secret %q at %q path has malformed %q arn", p.Config.ID, path, aws.ToString(output.ARN))
+	}
+	if a.AccountID != p.Config.ExpectedAccount {
+		return fmt.Errorf("secret %q at %q path is in %q account, not in expected %q account", p.Config.ID, path, a.AccountID, p.Config.ExpectedAccount)
+	}
+	return nil
+}
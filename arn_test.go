@@ -0,0 +1,207 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testSecretARN = "arn:aws:secretsmanager:us-west-2:123456789012:secret:authcrunch/caddy/access_token-AbCdEf"
+
+// newMockARNSecret returns a mock HTTP client responding with the secret
+// having the ARN in the region of the request, unless the region is
+// unavailable.
+// It records the region and the secret id of every request.
+func newMockARNSecret(t *testing.T, arn string, failing map[string]bool, requests *[]string) smithyhttp.ClientDoFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed reading request body: %v", err)
+		}
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("failed parsing request body: %v", err)
+		}
+		region := strings.Split(r.URL.Host, ".")[1]
+		*requests = append(*requests, fmt.Sprintf("%s %s", region, m["SecretId"]))
+		if failing[region] {
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     http.Header{},
+				Body: ioutil.NopCloser(strings.NewReader(packMapToJSON(t, map[string]interface{}{
+					"__type":  "ServiceUnavailableException",
+					"Message": "Secrets Manager is unavailable.",
+				}))),
+			}, nil
+		}
+		response := packMapToJSON(t, map[string]interface{}{
+			"ARN":          regionalPath(arn, region),
+			"SecretString": packMapToJSON(t, map[string]interface{}{"username": "jsmith"}),
+		})
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(response)),
+		}, nil
+	}
+}
+
+func TestSecretARN(t *testing.T) {
+	testcases := []struct {
+		name      string
+		cfg       string
+		arn       string
+		failing   map[string]bool
+		want      []string
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test arn path with inferred region",
+			cfg:  `{"id":"foo","path":"` + testSecretARN + `","load":"lazy"}`,
+			arn:  testSecretARN,
+			want: []string{"us-west-2 " + testSecretARN},
+		},
+		{
+			name:    "test arn path with failover region",
+			cfg:     `{"id":"foo","path":"` + testSecretARN + `","failover_region":"us-east-1","load":"lazy"}`,
+			arn:     testSecretARN,
+			failing: map[string]bool{"us-west-2": true},
+			want: []string{
+				"us-west-2 " + testSecretARN,
+				"us-east-1 " + strings.Replace(testSecretARN, "us-west-2", "us-east-1", 1),
+			},
+		},
+		{
+			name: "test name path in expected account",
+			cfg:  `{"id":"foo","path":"authcrunch/caddy/access_token","region":"us-west-2","expected_account":"123456789012","load":"lazy"}`,
+			arn:  testSecretARN,
+			want: []string{"us-west-2 authcrunch/caddy/access_token"},
+		},
+		{
+			name:      "test name path in unexpected account",
+			cfg:       `{"id":"foo","path":"authcrunch/caddy/access_token","region":"us-west-2","expected_account":"210987654321","load":"lazy"}`,
+			arn:       testSecretARN,
+			want:      []string{"us-west-2 authcrunch/caddy/access_token"},
+			shouldErr: true,
+			err: fmt.Errorf("secret %q at %q path is in %q account, not in expected %q account",
+				"foo", "authcrunch/caddy/access_token", "123456789012", "210987654321"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProvisionedPlugin(t, tc.cfg)
+			defer p.Cleanup()
+
+			var requests []string
+			for _, c := range p.clients {
+				setMockRegionClient(c, newMockARNSecret(t, tc.arn, tc.failing, &requests))
+			}
+
+			secret, err := p.GetSecret(context.TODO())
+			if diff := cmp.Diff(tc.want, requests); diff != "" {
+				t.Errorf("unexpected requests (-want +got):\n%s", diff)
+			}
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatalf("unexpected success, want: %v", tc.err)
+				}
+				if diff := cmp.Diff(tc.err.Error(), err.Error()); diff != "" {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(map[string]interface{}{"username": "jsmith"}, secret); diff != "" {
+				t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateARNs(t *testing.T) {
+	testcases := []struct {
+		name string
+		cfg  *Config
+		err  error
+	}{
+		{
+			name: "test config with arn path without region",
+			cfg:  &Config{ID: "foo", Path: testSecretARN},
+		},
+		{
+			name: "test config with arn path in region",
+			cfg:  &Config{ID: "foo", Path: testSecretARN, Region: "us-west-2", ExpectedAccount: "123456789012"},
+		},
+		{
+			name: "test config with arn path in conflicting region",
+			cfg:  &Config{ID: "foo", Path: testSecretARN, Region: "us-east-1"},
+			err:  fmt.Errorf("secret %q has %q region, but its %q arn is in %q region", "foo", "us-east-1", testSecretARN, "us-west-2"),
+		},
+		{
+			name: "test config with arn paths in different regions",
+			cfg: &Config{ID: "foo", Paths: []string{
+				testSecretARN,
+				strings.Replace(testSecretARN, "us-west-2", "eu-west-1", 1),
+			}},
+			err: fmt.Errorf("secret %q has %q region, but its %q arn is in %q region",
+				"foo", "us-west-2", strings.Replace(testSecretARN, "us-west-2", "eu-west-1", 1), "eu-west-1"),
+		},
+		{
+			name: "test config with arn path in unexpected account",
+			cfg:  &Config{ID: "foo", Path: testSecretARN, ExpectedAccount: "210987654321"},
+			err:  fmt.Errorf("secret %q has %q arn, but it is not in expected %q account", "foo", testSecretARN, "210987654321"),
+		},
+		{
+			name: "test config with arn of other service",
+			cfg:  &Config{ID: "foo", Path: "arn:aws:ssm:us-west-2:123456789012:parameter/caddy"},
+			err: fmt.Errorf("secret %q has malformed %q arn: not a secrets manager secret",
+				"foo", "arn:aws:ssm:us-west-2:123456789012:parameter/caddy"),
+		},
+		{
+			name: "test config with malformed expected account",
+			cfg:  &Config{ID: "foo", Path: testSecretARN, ExpectedAccount: "1234"},
+			err:  fmt.Errorf("secret %q has malformed %q expected account", "foo", "1234"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateARNs(tc.cfg)
+			if tc.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.err.Error(), err.Error()); diff != "" {
+				t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+}
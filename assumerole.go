@@ -0,0 +1,106 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+var (
+	roleARNRgx     = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+	sessionNameRgx = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+)
+
+const (
+	minSessionDuration = 15 * time.Minute
+	maxSessionDuration = 12 * time.Hour
+
+	// credentialsExpiryWindow is the time before the expiration of the
+	// assumed role credentials when they are refreshed.
+	credentialsExpiryWindow = 5 * time.Minute
+)
+
+// validateAssumeRole validates the role assumption settings of the
+// configuration.
+func validateAssumeRole(cfg *Config) error {
+	if cfg.RoleARN == "" {
+		if cfg.ExternalID != "" || cfg.SessionName != "" || cfg.SessionDuration != 0 || cfg.SourceProfile != "" ||
+			cfg.WebIdentityTokenFile != "" || cfg.STSEndpoint != "" {
+			return fmt.Errorf("secret %q has assume role settings, but it has no role arn", cfg.ID)
+		}
+		return nil
+	}
+	if !roleARNRgx.MatchString(cfg.RoleARN) {
+		return fmt.Errorf("secret %q has malformed %q role arn", cfg.ID, cfg.RoleARN)
+	}
+	if cfg.WebIdentityTokenFile != "" && (cfg.ExternalID != "" || cfg.SourceProfile != "") {
+		return fmt.Errorf("secret %q has web identity token file, but it also has external id or source profile", cfg.ID)
+	}
+	if cfg.STSEndpoint != "" && !isEndpointURL(cfg.STSEndpoint) {
+		return fmt.Errorf("secret %q has malformed %q sts endpoint", cfg.ID, cfg.STSEndpoint)
+	}
+	if cfg.SessionName != "" && !sessionNameRgx.MatchString(cfg.SessionName) {
+		return fmt.Errorf("secret %q has malformed %q session name", cfg.ID, cfg.SessionName)
+	}
+	if cfg.SessionDuration != 0 {
+		d := time.Duration(cfg.SessionDuration)
+		if d < minSessionDuration || d > maxSessionDuration {
+			return fmt.Errorf("secret %q has session duration %v out of %v to %v range", cfg.ID, d, minSessionDuration, maxSessionDuration)
+		}
+	}
+	return nil
+}
+
+// newAssumeRoleCredentials returns the credentials of the assumed role. With
+// a web identity token file, the role is assumed with the token, which is
+// read again every time the credentials are retrieved, so that the rotated
+// tokens are picked up. Otherwise, the role is assumed with the source
+// credentials. The credentials are cached and refreshed before they expire.
+func newAssumeRoleCredentials(cfg *clientConfig, source aws.Config) aws.CredentialsProvider {
+	stsClient := sts.NewFromConfig(source, func(o *sts.Options) {
+		if cfg.STSEndpoint != "" {
+			o.EndpointResolver = sts.EndpointResolverFromURL(cfg.STSEndpoint)
+		}
+	})
+
+	var provider aws.CredentialsProvider
+	if cfg.WebIdentityTokenFile != "" {
+		provider = stscreds.NewWebIdentityRoleProvider(stsClient, cfg.RoleARN, stscreds.IdentityTokenFile(cfg.WebIdentityTokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = cfg.SessionName
+			o.Duration = cfg.SessionDuration
+		})
+	} else {
+		provider = stscreds.NewAssumeRoleProvider(stsClient, cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+			if cfg.SessionName != "" {
+				o.RoleSessionName = cfg.SessionName
+			}
+			if cfg.SessionDuration != 0 {
+				o.Duration = cfg.SessionDuration
+			}
+		})
+	}
+	return aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsExpiryWindow
+	})
+}
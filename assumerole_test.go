@@ -0,0 +1,319 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+// mockSTS is a mock HTTP client answering the AssumeRole calls of AWS STS
+// and passing the other requests to the next client. The credentials it
+// issues expire after the lifetime.
+type mockSTS struct {
+	t        *testing.T
+	next     smithyhttp.ClientDoFunc
+	lifetime time.Duration
+
+	mu        sync.Mutex
+	calls     []url.Values
+	accessKey []string
+}
+
+func (m *mockSTS) Do(r *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(r.URL.Host, "sts.") {
+		m.mu.Lock()
+		m.accessKey = append(m.accessKey, parseAccessKey(r.Header.Get("Authorization")))
+		m.mu.Unlock()
+		return m.next(r)
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		m.t.Fatalf("failed reading request body: %v", err)
+	}
+	params, err := url.ParseQuery(string(b))
+	if err != nil {
+		m.t.Fatalf("failed parsing request body: %v", err)
+	}
+	m.mu.Lock()
+	m.calls = append(m.calls, params)
+	n := len(m.calls)
+	m.mu.Unlock()
+
+	body := fmt.Sprintf(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAMOCK%d</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, n, time.Now().Add(m.lifetime).UTC().Format(time.RFC3339))
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/xml"}},
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+	}, nil
+}
+
+// parseAccessKey returns the access key of the SigV4 authorization header.
+func parseAccessKey(s string) string {
+	i := strings.Index(s, "Credential=")
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len("Credential="):]
+	if j := strings.Index(s, "/"); j >= 0 {
+		s = s[:j]
+	}
+	return s
+}
+
+func TestAssumeRole(t *testing.T) {
+	testcases := []struct {
+		name          string
+		cfg           *clientConfig
+		lifetime      time.Duration
+		wantParams    map[string]string
+		wantAssumes   int
+		wantAccessKey []string
+	}{
+		{
+			name: "test assume role with external id and session settings",
+			cfg: &clientConfig{
+				ID:              "foo",
+				Region:          "us-east-1",
+				RoleARN:         "arn:aws:iam::123456789012:role/caddy-secrets",
+				ExternalID:      "b6c2c4f0",
+				SessionName:     "caddy-foo",
+				SessionDuration: time.Hour,
+			},
+			lifetime: time.Hour,
+			wantParams: map[string]string{
+				"Action":          "AssumeRole",
+				"RoleArn":         "arn:aws:iam::123456789012:role/caddy-secrets",
+				"ExternalId":      "b6c2c4f0",
+				"RoleSessionName": "caddy-foo",
+				"DurationSeconds": "3600",
+			},
+			wantAssumes:   1,
+			wantAccessKey: []string{"ASIAMOCK1", "ASIAMOCK1"},
+		},
+		{
+			name: "test assume role refreshing expiring credentials",
+			cfg: &clientConfig{
+				ID:      "foo",
+				Region:  "us-east-1",
+				RoleARN: "arn:aws:iam::123456789012:role/caddy-secrets",
+			},
+			lifetime: time.Minute,
+			wantParams: map[string]string{
+				"Action":  "AssumeRole",
+				"RoleArn": "arn:aws:iam::123456789012:role/caddy-secrets",
+			},
+			wantAssumes:   2,
+			wantAccessKey: []string{"ASIAMOCK1", "ASIAMOCK2"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newClient(context.TODO(), tc.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error during client initialization: %v", err)
+			}
+			var requests []map[string]interface{}
+			recorder := newMockRequestRecorder(t, map[string]interface{}{"foo": "bar"}, &requests)
+			mock := &mockSTS{t: t, next: recorder.Do, lifetime: tc.lifetime}
+			c.SetMockClient(smithyhttp.ClientDoFunc(mock.Do))
+			c.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+			for i := 0; i < 2; i++ {
+				if _, err := c.GetSecretValue(context.TODO(), &secretRequest{Path: "foo/bar"}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if len(mock.calls) != tc.wantAssumes {
+				t.Fatalf("AssumeRole call count mismatch: want %d, got %d", tc.wantAssumes, len(mock.calls))
+			}
+			params := make(map[string]string)
+			for k := range tc.wantParams {
+				params[k] = mock.calls[0].Get(k)
+			}
+			if _, exists := tc.wantParams["RoleSessionName"]; !exists && mock.calls[0].Get("RoleSessionName") == "" {
+				t.Errorf("AssumeRole request has no default session name")
+			}
+			if diff := cmp.Diff(tc.wantParams, params); diff != "" {
+				t.Errorf("AssumeRole request mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantAccessKey, mock.accessKey); diff != "" {
+				t.Errorf("GetSecretValue() access key mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWebIdentity(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("token-1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var tokens, sessionNames, accessKeys []string
+	stsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed parsing request body: %v", err)
+		}
+		if got := r.PostForm.Get("Action"); got != "AssumeRoleWithWebIdentity" {
+			t.Errorf("unexpected %q action", got)
+		}
+		mu.Lock()
+		tokens = append(tokens, r.PostForm.Get("WebIdentityToken"))
+		sessionNames = append(sessionNames, r.PostForm.Get("RoleSessionName"))
+		n := len(tokens)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/xml")
+		// The credentials expire within the expiry window, so that they are
+		// refreshed before every request.
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAWEB%d</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, n, time.Now().Add(time.Minute).UTC().Format(time.RFC3339))
+	}))
+	defer stsServer.Close()
+
+	smServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		accessKeys = append(accessKeys, parseAccessKey(r.Header.Get("Authorization")))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"Name":"foo/bar","SecretString":"{\"foo\":\"bar\"}"}`)
+	}))
+	defer smServer.Close()
+
+	c, err := newClient(context.TODO(), &clientConfig{
+		ID:                   "foo",
+		Region:               "us-east-1",
+		Endpoint:             smServer.URL,
+		RoleARN:              "arn:aws:iam::123456789012:role/caddy-secrets",
+		SessionName:          "caddy-foo",
+		WebIdentityTokenFile: tokenFile,
+		STSEndpoint:          stsServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error during client initialization: %v", err)
+	}
+
+	if _, err := c.GetSecretValue(context.TODO(), &secretRequest{Path: "foo/bar"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(tokenFile, []byte("token-2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSecretValue(context.TODO(), &secretRequest{Path: "foo/bar"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"token-1", "token-2"}, tokens); diff != "" {
+		t.Errorf("AssumeRoleWithWebIdentity() token mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"caddy-foo", "caddy-foo"}, sessionNames); diff != "" {
+		t.Errorf("AssumeRoleWithWebIdentity() session name mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"ASIAWEB1", "ASIAWEB2"}, accessKeys); diff != "" {
+		t.Errorf("GetSecretValue() access key mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateAssumeRole(t *testing.T) {
+	testcases := []struct {
+		name string
+		cfg  *Config
+		err  error
+	}{
+		{
+			name: "test config without assume role",
+			cfg:  &Config{ID: "foo"},
+		},
+		{
+			name: "test config with role arn in other partition",
+			cfg:  &Config{ID: "foo", RoleARN: "arn:aws-us-gov:iam::123456789012:role/path/caddy"},
+		},
+		{
+			name: "test config with malformed session name",
+			cfg:  &Config{ID: "foo", RoleARN: "arn:aws:iam::123456789012:role/caddy", SessionName: "caddy session"},
+			err:  fmt.Errorf("secret %q has malformed %q session name", "foo", "caddy session"),
+		},
+		{
+			name: "test config with web identity token file and external id",
+			cfg:  &Config{ID: "foo", RoleARN: "arn:aws:iam::123456789012:role/caddy", WebIdentityTokenFile: "/var/run/token", ExternalID: "b6c2c4f0"},
+			err:  fmt.Errorf("secret %q has web identity token file, but it also has external id or source profile", "foo"),
+		},
+		{
+			name: "test config with malformed sts endpoint",
+			cfg:  &Config{ID: "foo", RoleARN: "arn:aws:iam::123456789012:role/caddy", STSEndpoint: "localhost:4566"},
+			err:  fmt.Errorf("secret %q has malformed %q sts endpoint", "foo", "localhost:4566"),
+		},
+		{
+			name: "test config with web identity token file without role arn",
+			cfg:  &Config{ID: "foo", WebIdentityTokenFile: "/var/run/token"},
+			err:  fmt.Errorf("secret %q has assume role settings, but it has no role arn", "foo"),
+		},
+		{
+			name: "test config with source profile without role arn",
+			cfg:  &Config{ID: "foo", SourceProfile: "ops"},
+			err:  fmt.Errorf("secret %q has assume role settings, but it has no role arn", "foo"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateAssumeRole(tc.cfg)
+			if tc.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.err.Error(), err.Error()); diff != "" {
+				t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// secretSnapshot is a fetched secret along with the IDs of the fetched
+// versions, keyed by path, and the time it was fetched. A snapshot loaded
+// from the cache file is marked as such.
+type secretSnapshot struct {
+	secret        map[string]interface{}
+	versions      map[string]string
+	fetchedAt     time.Time
+	fromCacheFile bool
+}
+
+// newSecretSnapshot returns a snapshot of the secret fetched now.
+func newSecretSnapshot(secret map[string]interface{}, versions map[string]string) *secretSnapshot {
+	return &secretSnapshot{
+		secret:    secret,
+		versions:  versions,
+		fetchedAt: time.Now(),
+	}
+}
+
+// copySecretValue returns a deep copy of a value of the secret, so that the
+// callers modifying the returned value do not modify the cached snapshot.
+func copySecretValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = copySecretValue(item)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, item := range v {
+			arr[i] = copySecretValue(item)
+		}
+		return arr
+	case []byte:
+		return append([]byte(nil), v...)
+	default:
+		return v
+	}
+}
+
+// secretCache holds the last fetched secret. The cached snapshot is
+// immutable and it is replaced atomically, so that the readers never observe
+// a partially updated secret and need no locking.
+type secretCache struct {
+	v atomic.Value
+}
+
+// load returns the cached snapshot, or nil when the cache is empty.
+func (c *secretCache) load() *secretSnapshot {
+	snapshot, _ := c.v.Load().(*secretSnapshot)
+	return snapshot
+}
+
+// store replaces the cached snapshot, unless the cached one was fetched
+// later, so that a slow fetch does not override the result of a faster one.
+// It reports whether the snapshot was stored.
+func (c *secretCache) store(snapshot *secretSnapshot) bool {
+	for {
+		old := c.v.Load()
+		if current, _ := old.(*secretSnapshot); current != nil && current.fetchedAt.After(snapshot.fetchedAt) {
+			return false
+		}
+		if c.v.CompareAndSwap(old, snapshot) {
+			return true
+		}
+	}
+}
+
+// clear empties the cache.
+func (c *secretCache) clear() {
+	c.v.Store((*secretSnapshot)(nil))
+}
+
+// validateCachePolicy validates the cache policy settings of the
+// configuration.
+func validateCachePolicy(cfg *Config) error {
+	if cfg.CacheTTL < 0 {
+		return fmt.Errorf("secret %q has negative cache ttl", cfg.ID)
+	}
+	if cfg.MaxStale < 0 {
+		return fmt.Errorf("secret %q has negative max stale", cfg.ID)
+	}
+	if cfg.CacheTTL == 0 && (cfg.MaxStale > 0 || cfg.StaleOnError || cfg.StaleWhileRevalidate) {
+		return fmt.Errorf("secret %q has stale cache policy, but it has no cache ttl", cfg.ID)
+	}
+	if cfg.MaxStale > 0 && !cfg.StaleOnError && !cfg.StaleWhileRevalidate {
+		return fmt.Errorf("secret %q has max stale, but it has neither stale on error nor stale while revalidate", cfg.ID)
+	}
+	return nil
+}
+
+// getSecret returns the cached secret, unless it expired. When the cache is
+// empty or the cached secret expired, the secret is fetched, and the second
+// return value is true. Concurrent fetches are collapsed into a single call
+// to AWS Secrets Manager. Depending on the cache policy, an expired secret is
+// served while it is refetched in the background, or when the refetch fails.
+// The secret loaded from the cache file is served until a refetch succeeds.
+func (p *Plugin) getSecret(ctx context.Context) (map[string]interface{}, bool, error) {
+	snapshot := p.cache.load()
+	if snapshot == nil && p.Config.Load == loadLazy {
+		snapshot, err := p.flights.do(flightInit, func() (*secretSnapshot, error) {
+			return p.initSecret(ctx)
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		return snapshot.secret, true, nil
+	}
+	if snapshot == nil {
+		snapshot, err := p.flights.do(flightFetch, func() (*secretSnapshot, error) {
+			return p.fetchSecret(ctx)
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		return snapshot.secret, true, nil
+	}
+
+	ttl := time.Duration(p.Config.CacheTTL)
+	age := time.Since(snapshot.fetchedAt)
+	if ttl <= 0 || age <= ttl {
+		return snapshot.secret, false, nil
+	}
+
+	maxStale := time.Duration(p.Config.MaxStale)
+	servable := maxStale <= 0 || age <= ttl+maxStale
+
+	if p.Config.StaleWhileRevalidate && servable {
+		p.logStaleServe(snapshot, "revalidating", nil)
+		p.revalidate()
+		return snapshot.secret, false, nil
+	}
+
+	reloaded, err := p.flights.do(flightReload, func() (*secretSnapshot, error) {
+		return p.reloadSecret(ctx, snapshot)
+	})
+	if err != nil {
+		switch {
+		case snapshot.fromCacheFile:
+			p.logStaleServe(snapshot, "refetch failed, serving cache file", err)
+			return snapshot.secret, false, nil
+		case p.Config.StaleOnError && servable:
+			p.logStaleServe(snapshot, "refetch failed", err)
+			return snapshot.secret, false, nil
+		}
+		return nil, true, err
+	}
+	p.storeSecret(reloaded)
+	return reloaded.secret, true, nil
+}
+
+// revalidate refetches the secret in the background, unless a refetch is
+// already in progress.
+func (p *Plugin) revalidate() {
+	if !atomic.CompareAndSwapUint32(&p.revalidating, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreUint32(&p.revalidating, 0)
+		p.refresh(p.ctx)
+	}()
+}
+
+func (p *Plugin) logStaleServe(snapshot *secretSnapshot, reason string, err error) {
+	fields := []zap.Field{
+		zap.String("plugin_name", p.Name),
+		zap.String("secret_id", p.Config.ID),
+		zap.String("reason", reason),
+		zap.Duration("age", time.Since(snapshot.fetchedAt)),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	p.logger.Warn("serving stale secret", fields...)
+}
@@ -0,0 +1,202 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+func TestCachePolicy(t *testing.T) {
+	cached := map[string]interface{}{"password": "foo"}
+	fetched := map[string]interface{}{"password": "bar"}
+
+	testcases := []struct {
+		name         string
+		cfg          string
+		age          time.Duration
+		failing      bool
+		want         map[string]interface{}
+		wantRequests int
+		wantEventual map[string]interface{}
+		shouldErr    bool
+		err          error
+	}{
+		{
+			name: "test cached secret without ttl",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1"}`,
+			age:  time.Hour,
+			want: cached,
+		},
+		{
+			name: "test cached secret within ttl",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000}`,
+			age:  30 * time.Second,
+			want: cached,
+		},
+		{
+			name:         "test expired secret",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000}`,
+			age:          90 * time.Second,
+			want:         fetched,
+			wantRequests: 1,
+		},
+		{
+			name:         "test expired secret with failed refetch",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000}`,
+			age:          90 * time.Second,
+			failing:      true,
+			wantRequests: 1,
+			shouldErr:    true,
+			err: fmt.Errorf("operation error Secrets Manager: GetSecretValue, https response error StatusCode: 400, RequestID: , " +
+				"ResourceNotFoundException: Secrets Manager can't find the specified secret."),
+		},
+		{
+			name:         "test expired secret with failed refetch and stale on error",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"stale_on_error":true}`,
+			age:          90 * time.Second,
+			failing:      true,
+			want:         cached,
+			wantRequests: 1,
+		},
+		{
+			name:         "test expired secret with failed refetch and stale on error within max stale",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"stale_on_error":true,"max_stale":60000000000}`,
+			age:          90 * time.Second,
+			failing:      true,
+			want:         cached,
+			wantRequests: 1,
+		},
+		{
+			name:         "test expired secret with failed refetch and stale on error beyond max stale",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"stale_on_error":true,"max_stale":60000000000}`,
+			age:          150 * time.Second,
+			failing:      true,
+			wantRequests: 1,
+			shouldErr:    true,
+			err: fmt.Errorf("operation error Secrets Manager: GetSecretValue, https response error StatusCode: 400, RequestID: , " +
+				"ResourceNotFoundException: Secrets Manager can't find the specified secret."),
+		},
+		{
+			name:         "test expired secret with stale while revalidate",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"stale_while_revalidate":true}`,
+			age:          90 * time.Second,
+			want:         cached,
+			wantEventual: fetched,
+		},
+		{
+			name:         "test expired secret with stale while revalidate beyond max stale",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"stale_while_revalidate":true,"max_stale":60000000000}`,
+			age:          150 * time.Second,
+			want:         fetched,
+			wantRequests: 1,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			p := &Plugin{
+				ConfigRaw: json.RawMessage(tc.cfg),
+			}
+			if err := p.Provision(ctx); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			mock := &mockRotatingSecret{t: t, secret: fetched, failing: tc.failing}
+			p.client.SetMockClient(mock)
+			p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+			p.cache.v.Store(&secretSnapshot{secret: cached, fetchedAt: time.Now().Add(-tc.age)})
+
+			got, err := p.GetSecret(context.TODO())
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("GetSecret() error mismatch (-want +got):\n%s", diff)
+				}
+			} else {
+				if tc.shouldErr {
+					t.Fatalf("unexpected success, want: %v", tc.err)
+				}
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+				}
+			}
+
+			if tc.wantEventual != nil {
+				waitForValue(t, p, "password", tc.wantEventual["password"])
+				return
+			}
+			if n := mock.count(); n != tc.wantRequests {
+				t.Errorf("unexpected number of requests: want %d, got %d", tc.wantRequests, n)
+			}
+		})
+	}
+}
+
+func TestSecretCopy(t *testing.T) {
+	cached := map[string]interface{}{
+		"password": "foo",
+		"db":       map[string]interface{}{"hosts": []interface{}{"db1", "db2"}},
+	}
+	want := copySecretValue(cached)
+
+	p := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1"}`)
+	defer p.Cleanup()
+	p.cache.v.Store(&secretSnapshot{secret: cached, fetchedAt: time.Now()})
+
+	// The readers modifying their values race neither with each other nor
+	// with the cached snapshot.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			secret, err := p.GetSecret(context.TODO())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			secret["password"] = fmt.Sprintf("bar%d", i)
+			secret["db"].(map[string]interface{})["hosts"].([]interface{})[0] = "evil"
+			db, err := p.GetSecretByKey(context.TODO(), "db")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			db.(map[string]interface{})["hosts"] = nil
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := p.GetSecret(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cacheFileVersion is the version of the format of the cache file.
+const cacheFileVersion = 2
+
+// cacheKeySize is the size of the AES-256 key encrypting the cache file.
+const cacheKeySize = 32
+
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// cacheFileEnvelope is the content of the cache file. The secret and its
+// versions are encrypted with AES-GCM. The ID of the secret, the binding to
+// its settings and the time it was fetched are authenticated, so that none
+// can be altered, and a file of one secret cannot be used for another, nor
+// for the same secret with other settings.
+type cacheFileEnvelope struct {
+	Version    int       `json:"version"`
+	Binding    string    `json:"binding"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// cacheFilePayload is the encrypted part of the cache file.
+type cacheFilePayload struct {
+	Secret   map[string]interface{}
+	Versions map[string]string
+}
+
+// validateCacheFile validates the cache file settings of the configuration.
+// The key is read during provisioning.
+func validateCacheFile(cfg *Config) error {
+	if cfg.CacheFile == "" {
+		if cfg.CacheKeyEnv != "" || cfg.CacheKeyFile != "" || cfg.CacheFileMaxAge != 0 {
+			return fmt.Errorf("secret %q has cache file settings, but it has no cache file", cfg.ID)
+		}
+		return nil
+	}
+	if cfg.CacheKeyEnv == "" && cfg.CacheKeyFile == "" {
+		return fmt.Errorf("secret %q has cache file, but it has no cache key", cfg.ID)
+	}
+	if cfg.CacheKeyEnv != "" && cfg.CacheKeyFile != "" {
+		return fmt.Errorf("secret %q has both cache key env and cache key file", cfg.ID)
+	}
+	if cfg.CacheFileMaxAge < 0 {
+		return fmt.Errorf("secret %q has negative cache file max age", cfg.ID)
+	}
+	return nil
+}
+
+// loadCacheKey returns the key encrypting the cache file. The key is a
+// base64-encoded 32-byte value stored either in an environment variable or
+// in a file.
+func loadCacheKey(cfg *Config) ([]byte, error) {
+	var s string
+	switch {
+	case cfg.CacheKeyEnv != "":
+		v, exists := os.LookupEnv(cfg.CacheKeyEnv)
+		if !exists {
+			return nil, fmt.Errorf("secret %q has cache key env %q, but it is not set", cfg.ID, cfg.CacheKeyEnv)
+		}
+		s = v
+	case cfg.CacheKeyFile != "":
+		content, err := ioutil.ReadFile(cfg.CacheKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("secret %q has unreadable cache key file: %v", cfg.ID, err)
+		}
+		s = string(content)
+	default:
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != cacheKeySize {
+		return nil, fmt.Errorf("secret %q has malformed cache key, it must be a base64-encoded %d-byte value", cfg.ID, cacheKeySize)
+	}
+	return key, nil
+}
+
+// secretBinding returns the digest of the settings selecting, shaping and
+// validating the secret, i.e. its regions, paths, versions, format,
+// transforms, required keys and schema.
+func secretBinding(cfg *Config, schema *jsonSchema) string {
+	var schemaDigest string
+	if schema != nil {
+		schemaDigest = schema.digest
+	}
+	b, _ := json.Marshal(&struct {
+		Regions         []string          `json:"regions"`
+		Paths           []string          `json:"paths"`
+		MergePolicy     string            `json:"merge_policy"`
+		PathPrefix      string            `json:"path_prefix"`
+		Tags            map[string]string `json:"tags"`
+		VersionStage    string            `json:"version_stage"`
+		VersionID       string            `json:"version_id"`
+		Format          string            `json:"format"`
+		ValueKey        string            `json:"value_key"`
+		Encoding        string            `json:"encoding"`
+		Aliases         map[string]string `json:"aliases"`
+		Only            []string          `json:"only"`
+		Derived         map[string]string `json:"derived"`
+		ExpectedAccount string            `json:"expected_account"`
+		Required        []string          `json:"required"`
+		Schema          string            `json:"schema"`
+	}{
+		Regions:         cfg.getRegions(),
+		Paths:           cfg.getPaths(),
+		MergePolicy:     cfg.MergePolicy,
+		PathPrefix:      cfg.PathPrefix,
+		Tags:            cfg.Tags,
+		VersionStage:    cfg.VersionStage,
+		VersionID:       cfg.VersionID,
+		Format:          cfg.Format,
+		ValueKey:        cfg.ValueKey,
+		Encoding:        cfg.Encoding,
+		Aliases:         cfg.Aliases,
+		Only:            cfg.Only,
+		Derived:         cfg.Derived,
+		ExpectedAccount: cfg.ExpectedAccount,
+		Required:        cfg.Required,
+		Schema:          schemaDigest,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeCacheFile encrypts the snapshot with the key and writes it to the
+// cache file, bound to the settings of the secret. The file is replaced
+// atomically and it is readable by the owner only.
+func writeCacheFile(cfg *Config, binding string, key []byte, snapshot *secretSnapshot) error {
+	var plaintext bytes.Buffer
+	payload := &cacheFilePayload{
+		Secret:   snapshot.secret,
+		Versions: snapshot.versions,
+	}
+	if err := gob.NewEncoder(&plaintext).Encode(payload); err != nil {
+		return err
+	}
+
+	aead, err := newCacheCipher(key)
+	if err != nil {
+		return err
+	}
+	envelope := &cacheFileEnvelope{
+		Version:   cacheFileVersion,
+		Binding:   binding,
+		FetchedAt: snapshot.fetchedAt.UTC(),
+		Nonce:     make([]byte, aead.NonceSize()),
+	}
+	if _, err := rand.Read(envelope.Nonce); err != nil {
+		return err
+	}
+	envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, plaintext.Bytes(), cacheFileAAD(cfg, envelope))
+
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(cfg.CacheFile), filepath.Base(cfg.CacheFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cfg.CacheFile)
+}
+
+// readCacheFile reads the cache file and decrypts the snapshot with the key.
+// The file must be bound to the same settings of the secret.
+func readCacheFile(cfg *Config, binding string, key []byte) (*secretSnapshot, error) {
+	b, err := ioutil.ReadFile(cfg.CacheFile)
+	if err != nil {
+		return nil, err
+	}
+	envelope := &cacheFileEnvelope{}
+	if err := json.Unmarshal(b, envelope); err != nil {
+		return nil, fmt.Errorf("malformed cache file: %v", err)
+	}
+	if envelope.Version != cacheFileVersion {
+		return nil, fmt.Errorf("unsupported cache file version %d", envelope.Version)
+	}
+	if envelope.Binding != binding {
+		return nil, fmt.Errorf("cache file was written with other secret settings")
+	}
+
+	aead, err := newCacheCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("malformed cache file nonce")
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, cacheFileAAD(cfg, envelope))
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting cache file: %v", err)
+	}
+	payload := &cacheFilePayload{}
+	if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(payload); err != nil {
+		return nil, fmt.Errorf("malformed cache file payload: %v", err)
+	}
+	return &secretSnapshot{
+		secret:        payload.Secret,
+		versions:      payload.Versions,
+		fetchedAt:     envelope.FetchedAt,
+		fromCacheFile: true,
+	}, nil
+}
+
+func newCacheCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func cacheFileAAD(cfg *Config, envelope *cacheFileEnvelope) []byte {
+	return []byte(fmt.Sprintf("%d\n%s\n%s\n%s", envelope.Version, cfg.ID, envelope.Binding, envelope.FetchedAt.Format(time.RFC3339Nano)))
+}
+
+// storeSecret caches the snapshot, shares it with the next configuration
+// and, with a cache file, persists it. A failure to write the cache file is logged, but it does not fail the fetch.
+func (p *Plugin) storeSecret(snapshot *secretSnapshot) {
+	if !p.cache.store(snapshot) {
+		return
+	}
+	p.shareSecret(snapshot)
+	if p.Config.CacheFile == "" {
+		return
+	}
+	if written := p.persisted.load(); written != nil && !p.isCacheFileOutdated(written, snapshot) {
+		return
+	}
+	if err := writeCacheFile(&p.Config, p.binding, p.cacheKey, snapshot); err != nil {
+		p.logger.Warn(
+			"failed writing cache file",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.String("cache_file", p.Config.CacheFile),
+			zap.Error(err),
+		)
+		return
+	}
+	p.persisted.store(snapshot)
+}
+
+// isCacheFileOutdated reports whether the cache file holding the written
+// snapshot is rewritten with the fetched one. The file is kept while the
+// versions of the secret are unchanged, unless it is half way to its max age,
+// so that a refresh confirming the cached secret does not rewrite the file.
+func (p *Plugin) isCacheFileOutdated(written, fetched *secretSnapshot) bool {
+	if len(fetched.versions) == 0 || !reflect.DeepEqual(written.versions, fetched.versions) {
+		return true
+	}
+	maxAge := time.Duration(p.Config.CacheFileMaxAge)
+	return maxAge > 0 && fetched.fetchedAt.Sub(written.fetchedAt) >= maxAge/2
+}
+
+// fallbackToCacheFile returns the snapshot persisted in the cache file when
+// the secret cannot be fetched at startup. Otherwise, it returns the fetch
+// error.
+func (p *Plugin) fallbackToCacheFile(fetchErr error) (*secretSnapshot, error) {
+	snapshot, err := p.loadCacheFile()
+	if err != nil {
+		p.logger.Warn(
+			"failed loading cache file",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.String("cache_file", p.Config.CacheFile),
+			zap.Error(err),
+		)
+		return nil, fetchErr
+	}
+	p.logger.Warn(
+		"serving secret from cache file",
+		zap.String("plugin_name", p.Name),
+		zap.String("secret_id", p.Config.ID),
+		zap.String("cache_file", p.Config.CacheFile),
+		zap.Duration("age", time.Since(snapshot.fetchedAt)),
+		zap.Error(fetchErr),
+	)
+	return snapshot, nil
+}
+
+// loadCacheFile returns the snapshot persisted in the cache file, unless it
+// is older than the max age.
+func (p *Plugin) loadCacheFile() (*secretSnapshot, error) {
+	snapshot, err := readCacheFile(&p.Config, p.binding, p.cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	age := time.Since(snapshot.fetchedAt)
+	maxAge := time.Duration(p.Config.CacheFileMaxAge)
+	if maxAge > 0 && age > maxAge {
+		return nil, fmt.Errorf("cache file is %v old, exceeding %v max age", age.Round(time.Second), maxAge)
+	}
+	if err := validateSecret(&p.Config, snapshot.secret); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
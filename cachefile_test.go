@@ -0,0 +1,464 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+var testCacheKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestLoadCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, []byte(testCacheKey+"\n"), 0600); err != nil {
+		t.Fatalf("failed writing key file: %v", err)
+	}
+	t.Setenv("TEST_CACHE_KEY", testCacheKey)
+	t.Setenv("TEST_SHORT_CACHE_KEY", base64.StdEncoding.EncodeToString([]byte("foo")))
+
+	testcases := []struct {
+		name      string
+		cfg       *Config
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test key from env",
+			cfg:  &Config{ID: "foo", CacheKeyEnv: "TEST_CACHE_KEY"},
+		},
+		{
+			name: "test key from file",
+			cfg:  &Config{ID: "foo", CacheKeyFile: keyFile},
+		},
+		{
+			name:      "test unset env",
+			cfg:       &Config{ID: "foo", CacheKeyEnv: "TEST_UNSET_CACHE_KEY"},
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has cache key env %q, but it is not set", "foo", "TEST_UNSET_CACHE_KEY"),
+		},
+		{
+			name:      "test short key",
+			cfg:       &Config{ID: "foo", CacheKeyEnv: "TEST_SHORT_CACHE_KEY"},
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has malformed cache key, it must be a base64-encoded 32-byte value", "foo"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := loadCacheKey(tc.cfg)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("loadCacheKey() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if len(key) != cacheKeySize {
+				t.Fatalf("unexpected key size: %d", len(key))
+			}
+		})
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	key, _ := base64.StdEncoding.DecodeString(testCacheKey)
+	otherKey := make([]byte, cacheKeySize)
+	snapshot := &secretSnapshot{
+		secret: map[string]interface{}{
+			"password": "foo",
+			"db":       map[string]interface{}{"port": float64(5432)},
+			"hosts":    []interface{}{"a", "b"},
+			"raw":      []byte{0x01, 0x02},
+		},
+		versions:  map[string]string{"foo/bar": "v1"},
+		fetchedAt: time.Now().Add(-time.Hour).Truncate(time.Second),
+	}
+
+	testcases := []struct {
+		name       string
+		readCfg    *Config
+		readSchema string
+		readKey    []byte
+		shouldErr  bool
+	}{
+		{
+			name:    "test read with same key",
+			readCfg: &Config{ID: "foo"},
+			readKey: key,
+		},
+		{
+			name:      "test read with other key",
+			readCfg:   &Config{ID: "foo"},
+			readKey:   otherKey,
+			shouldErr: true,
+		},
+		{
+			name:      "test read for other secret",
+			readCfg:   &Config{ID: "bar"},
+			readKey:   key,
+			shouldErr: true,
+		},
+		{
+			name:      "test read for other path",
+			readCfg:   &Config{ID: "foo", Path: "foo/baz"},
+			readKey:   key,
+			shouldErr: true,
+		},
+		{
+			name:      "test read for other region",
+			readCfg:   &Config{ID: "foo", Region: "us-west-2"},
+			readKey:   key,
+			shouldErr: true,
+		},
+		{
+			name:      "test read for other version stage",
+			readCfg:   &Config{ID: "foo", VersionStage: "AWSPREVIOUS"},
+			readKey:   key,
+			shouldErr: true,
+		},
+		{
+			name:      "test read for other format",
+			readCfg:   &Config{ID: "foo", Format: "dotenv"},
+			readKey:   key,
+			shouldErr: true,
+		},
+		{
+			name:      "test read for other projection",
+			readCfg:   &Config{ID: "foo", Only: []string{"password"}},
+			readKey:   key,
+			shouldErr: true,
+		},
+		{
+			name:      "test read for other required keys",
+			readCfg:   &Config{ID: "foo", Required: []string{"password"}},
+			readKey:   key,
+			shouldErr: true,
+		},
+		{
+			name:       "test read for other schema",
+			readCfg:    &Config{ID: "foo"},
+			readSchema: `{"type": "object", "properties": {"password": {"minLength": 20}}}`,
+			readKey:    key,
+			shouldErr:  true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache")
+			writeCfg := &Config{ID: "foo", CacheFile: path}
+			if err := writeCacheFile(writeCfg, secretBinding(writeCfg, nil), key, snapshot); err != nil {
+				t.Fatalf("unexpected write error: %v", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("unexpected stat error: %v", err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Fatalf("unexpected cache file mode: %v", mode)
+			}
+
+			var schema *jsonSchema
+			if tc.readSchema != "" {
+				schema, err = parseSchema([]byte(tc.readSchema))
+				if err != nil {
+					t.Fatalf("unexpected schema error: %v", err)
+				}
+			}
+			tc.readCfg.CacheFile = path
+			got, err := readCacheFile(tc.readCfg, secretBinding(tc.readCfg, schema), tc.readKey)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success")
+			}
+			if diff := cmp.Diff(snapshot.secret, got.secret); diff != "" {
+				t.Errorf("readCacheFile() secret mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(snapshot.versions, got.versions); diff != "" {
+				t.Errorf("readCacheFile() versions mismatch (-want +got):\n%s", diff)
+			}
+			if !got.fetchedAt.Equal(snapshot.fetchedAt) {
+				t.Errorf("readCacheFile() fetched at mismatch: want %v, got %v", snapshot.fetchedAt, got.fetchedAt)
+			}
+		})
+	}
+}
+
+func TestCacheFileFallback(t *testing.T) {
+	t.Setenv("TEST_CACHE_KEY", testCacheKey)
+	fetchErr := fmt.Errorf("operation error Secrets Manager: GetSecretValue, https response error StatusCode: 503, RequestID: , " +
+		"api error ServiceUnavailableException: unavailable")
+
+	testcases := []struct {
+		name      string
+		maxAge    string
+		age       time.Duration
+		noFile    bool
+		failure   string
+		settings  string
+		want      map[string]interface{}
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test startup from cache file",
+			age:  time.Hour,
+			want: map[string]interface{}{"password": "foo"},
+		},
+		{
+			name:   "test startup from cache file within max age",
+			maxAge: `,"cache_file_max_age":7200000000000`,
+			age:    time.Hour,
+			want:   map[string]interface{}{"password": "foo"},
+		},
+		{
+			name:      "test startup from cache file beyond max age",
+			maxAge:    `,"cache_file_max_age":1800000000000`,
+			age:       time.Hour,
+			shouldErr: true,
+			err:       fetchErr,
+		},
+		{
+			name:      "test startup without cache file",
+			noFile:    true,
+			shouldErr: true,
+			err:       fetchErr,
+		},
+		{
+			name:      "test no startup from cache file with tightened schema",
+			age:       time.Hour,
+			settings:  `,"schema":{"type":"object","properties":{"password":{"minLength":20}}}`,
+			shouldErr: true,
+			err:       fetchErr,
+		},
+		{
+			name:      "test no startup from cache file with other required keys",
+			age:       time.Hour,
+			settings:  `,"required":["username"]`,
+			shouldErr: true,
+			err:       fetchErr,
+		},
+		{
+			name:      "test no startup from cache file of missing secret",
+			age:       time.Hour,
+			failure:   "not_found",
+			shouldErr: true,
+			err: fmt.Errorf("operation error Secrets Manager: GetSecretValue, https response error StatusCode: 400, RequestID: , " +
+				"ResourceNotFoundException: not_found"),
+		},
+		{
+			name:      "test no startup from cache file of denied secret",
+			age:       time.Hour,
+			failure:   "access_denied",
+			shouldErr: true,
+			err: fmt.Errorf("operation error Secrets Manager: GetSecretValue, https response error StatusCode: 400, RequestID: , " +
+				"api error AccessDeniedException: access_denied"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache")
+			cfg := fmt.Sprintf(`{"id":"foo","path":"foo/bar","region":"us-east-1","cache_file":%q,"cache_key_env":"TEST_CACHE_KEY"%s}`, path, tc.maxAge)
+
+			if !tc.noFile {
+				// A successful startup persists the secret.
+				p := &Plugin{ConfigRaw: json.RawMessage(cfg)}
+				if err := p.Provision(caddy.ActiveContext()); err != nil {
+					t.Fatalf("unexpected provisioning error: %v", err)
+				}
+				p.client.SetMockClient(&mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}})
+				p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+				if err := p.Validate(); err != nil {
+					t.Fatalf("unexpected validation error: %v", err)
+				}
+				snapshot, err := readCacheFile(&p.Config, p.binding, p.cacheKey)
+				if err != nil {
+					t.Fatalf("unexpected cache file error: %v", err)
+				}
+				snapshot.fetchedAt = time.Now().Add(-tc.age)
+				if err := writeCacheFile(&p.Config, p.binding, p.cacheKey, snapshot); err != nil {
+					t.Fatalf("unexpected write error: %v", err)
+				}
+				// The fetched secret is no longer shared once the instance is
+				// cleaned up.
+				if err := p.Cleanup(); err != nil {
+					t.Fatalf("unexpected cleanup error: %v", err)
+				}
+			}
+
+			p := &Plugin{ConfigRaw: json.RawMessage(strings.TrimSuffix(cfg, "}") + tc.settings + "}")}
+			if err := p.Provision(caddy.ActiveContext()); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			failure := tc.failure
+			if failure == "" {
+				failure = "unavailable"
+			}
+			setMockRegionClient(p.client, &mockRegion{t: t, failure: failure})
+			if err := p.Validate(); err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("Validate() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			got, err := p.GetSecret(context.TODO())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCacheFileFallbackExpired(t *testing.T) {
+	t.Setenv("TEST_CACHE_KEY", testCacheKey)
+	path := filepath.Join(t.TempDir(), "cache")
+	cfg := fmt.Sprintf(`{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"cache_file":%q,"cache_key_env":"TEST_CACHE_KEY"}`, path)
+
+	// A successful startup persists the secret.
+	prev := newProvisionedPlugin(t, cfg)
+	setMockRegionClient(prev.client, &mockRegion{t: t, secret: map[string]interface{}{"password": "foo"}})
+	if err := prev.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	snapshot, err := readCacheFile(&prev.Config, prev.binding, prev.cacheKey)
+	if err != nil {
+		t.Fatalf("unexpected cache file error: %v", err)
+	}
+	snapshot.fetchedAt = time.Now().Add(-time.Hour)
+	if err := writeCacheFile(&prev.Config, prev.binding, prev.cacheKey, snapshot); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	if err := prev.Cleanup(); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+
+	// The secret from the cache file is older than the cache ttl, but it is
+	// served while AWS is unavailable.
+	p := newProvisionedPlugin(t, cfg)
+	defer p.Cleanup()
+	mock := &mockRegion{t: t, secret: map[string]interface{}{"password": "bar"}, failure: "unavailable"}
+	setMockRegionClient(p.client, mock)
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := p.GetSecret(context.TODO())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(map[string]interface{}{"password": "foo"}, got); diff != "" {
+			t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	// Once AWS is available, the secret is fetched.
+	mock.setFailure("")
+	got, err := p.GetSecret(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"password": "bar"}, got); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+	if p.cache.load().fromCacheFile {
+		t.Errorf("fetched secret is marked as loaded from cache file")
+	}
+}
+
+func TestCacheFileRewrite(t *testing.T) {
+	t.Setenv("TEST_CACHE_KEY", testCacheKey)
+	path := filepath.Join(t.TempDir(), "cache")
+	cfg := fmt.Sprintf(`{"id":"foo","path":"foo/bar","region":"us-east-1","cache_file":%q,"cache_key_env":"TEST_CACHE_KEY"}`, path)
+
+	p := newProvisionedPlugin(t, cfg)
+	defer p.Cleanup()
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}, versionID: "v1"}
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	readFile := func() string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+		return string(b)
+	}
+	written := readFile()
+
+	// The refresh of the same version keeps the file.
+	if err := p.refresh(context.TODO()); err != nil {
+		t.Fatalf("unexpected refresh error: %v", err)
+	}
+	if n := mock.count(); n != 2 {
+		t.Fatalf("unexpected number of requests: want 2, got %d", n)
+	}
+	if readFile() != written {
+		t.Fatalf("cache file is rewritten with the same version")
+	}
+
+	// The refresh of another version rewrites the file.
+	mock.mu.Lock()
+	mock.versionID = "v2"
+	mock.mu.Unlock()
+	mock.set(map[string]interface{}{"password": "bar"}, false)
+	if err := p.refresh(context.TODO()); err != nil {
+		t.Fatalf("unexpected refresh error: %v", err)
+	}
+	if readFile() == written {
+		t.Fatalf("cache file is not rewritten with another version")
+	}
+	snapshot, err := readCacheFile(&p.Config, p.binding, p.cacheKey)
+	if err != nil {
+		t.Fatalf("unexpected cache file error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"password": "bar"}, snapshot.secret); diff != "" {
+		t.Errorf("readCacheFile() secret mismatch (-want +got):\n%s", diff)
+	}
+}
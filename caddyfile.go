@@ -40,18 +40,235 @@ func (p *Plugin) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 
 	for d.NextBlock(0) {
 		k := d.Val()
-		v := findReplaceAll(repl, d.RemainingArgs())
+		args := d.RemainingArgs()
+		v := findReplaceAll(repl, args)
 		switch k {
 		case "path":
 			if len(v) != 1 {
 				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
 			}
-			p.Config.Path = v[0]
+			switch {
+			case len(p.Config.Paths) > 0:
+				p.Config.Paths = append(p.Config.Paths, v[0])
+			case p.Config.Path != "":
+				p.Config.Paths = []string{p.Config.Path, v[0]}
+				p.Config.Path = ""
+			default:
+				p.Config.Path = v[0]
+			}
+		case "path_prefix":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.PathPrefix = v[0]
+		case "tag":
+			if len(v) != 2 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			if p.Config.Tags == nil {
+				p.Config.Tags = make(map[string]string)
+			}
+			p.Config.Tags[v[0]] = v[1]
+		case "merge_policy":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.MergePolicy = v[0]
 		case "region":
 			if len(v) != 1 {
 				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
 			}
 			p.Config.Region = v[0]
+		case "regions":
+			if len(v) == 0 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.Regions = append(p.Config.Regions, v...)
+		case "expected_account":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.ExpectedAccount = v[0]
+		case "failover_region":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.FailoverRegion = v[0]
+		case "version_stage":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.VersionStage = v[0]
+		case "version_id":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.VersionID = v[0]
+		case "format":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.Format = v[0]
+		case "value_key":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.ValueKey = v[0]
+		case "encoding":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.Encoding = v[0]
+		case "key":
+			if len(v) != 2 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			if p.Config.Aliases == nil {
+				p.Config.Aliases = make(map[string]string)
+			}
+			if _, exists := p.Config.Aliases[v[0]]; exists {
+				return d.Errf("field %q of %q secret has duplicate %q alias", k, p.Name, v[0])
+			}
+			p.Config.Aliases[v[0]] = v[1]
+		case "only":
+			if len(v) == 0 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.Only = append(p.Config.Only, v...)
+		case "require":
+			if len(v) == 0 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.Required = append(p.Config.Required, v...)
+		case "schema":
+			// The schema is not subject to placeholder replacement, because
+			// JSON objects would be mistaken for placeholders.
+			if len(args) != 1 || !json.Valid([]byte(args[0])) {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, args)
+			}
+			p.Config.Schema = json.RawMessage(args[0])
+		case "derive":
+			// The template is not subject to placeholder replacement, because
+			// template actions would be mistaken for placeholders.
+			if len(args) != 2 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, args)
+			}
+			if p.Config.Derived == nil {
+				p.Config.Derived = make(map[string]string)
+			}
+			if _, exists := p.Config.Derived[args[0]]; exists {
+				return d.Errf("field %q of %q secret has duplicate %q key", k, p.Name, args[0])
+			}
+			p.Config.Derived[args[0]] = args[1]
+		case "schema_file":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.SchemaFile = v[0]
+		case "refresh_interval", "cache_ttl", "max_stale", "negative_cache_ttl", "cache_file_max_age", "session_duration":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			duration, err := caddy.ParseDuration(v[0])
+			if err != nil {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax: %v", k, p.Name, v, err)
+			}
+			switch k {
+			case "refresh_interval":
+				p.Config.RefreshInterval = caddy.Duration(duration)
+			case "cache_ttl":
+				p.Config.CacheTTL = caddy.Duration(duration)
+			case "max_stale":
+				p.Config.MaxStale = caddy.Duration(duration)
+			case "negative_cache_ttl":
+				p.Config.NegativeCacheTTL = caddy.Duration(duration)
+			case "cache_file_max_age":
+				p.Config.CacheFileMaxAge = caddy.Duration(duration)
+			case "session_duration":
+				p.Config.SessionDuration = caddy.Duration(duration)
+			}
+		case "stale_on_error":
+			if len(v) != 0 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.StaleOnError = true
+		case "stale_while_revalidate":
+			if len(v) != 0 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.StaleWhileRevalidate = true
+		case "track_rotation":
+			if len(v) != 0 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.TrackRotation = true
+		case "miss_policy":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.MissPolicy = v[0]
+		case "endpoint":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.Endpoint = v[0]
+		case "use_fips", "use_dualstack":
+			if len(v) != 0 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			switch k {
+			case "use_fips":
+				p.Config.UseFIPS = true
+			case "use_dualstack":
+				p.Config.UseDualStack = true
+			}
+		case "profile", "shared_config_file", "shared_credentials_file":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			switch k {
+			case "profile":
+				p.Config.Profile = v[0]
+			case "shared_config_file":
+				p.Config.SharedConfigFile = v[0]
+			case "shared_credentials_file":
+				p.Config.SharedCredentialsFile = v[0]
+			}
+		case "role_arn", "external_id", "session_name", "source_profile", "web_identity_token_file", "sts_endpoint":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			switch k {
+			case "role_arn":
+				p.Config.RoleARN = v[0]
+			case "external_id":
+				p.Config.ExternalID = v[0]
+			case "session_name":
+				p.Config.SessionName = v[0]
+			case "source_profile":
+				p.Config.SourceProfile = v[0]
+			case "web_identity_token_file":
+				p.Config.WebIdentityTokenFile = v[0]
+			case "sts_endpoint":
+				p.Config.STSEndpoint = v[0]
+			}
+		case "load":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			p.Config.Load = v[0]
+		case "cache_file", "cache_key_env", "cache_key_file":
+			if len(v) != 1 {
+				return d.Errf("field %q of %q secret with value of %q has invalid syntax", k, p.Name, v)
+			}
+			switch k {
+			case "cache_file":
+				p.Config.CacheFile = v[0]
+			case "cache_key_env":
+				p.Config.CacheKeyEnv = v[0]
+			case "cache_key_file":
+				p.Config.CacheKeyFile = v[0]
+			}
 		default:
 			return d.Errf("unsupported %q field of %q secret with value of %q", k, p.Name, v)
 		}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/google/go-cmp/cmp"
@@ -58,6 +59,418 @@ func TestUnmarshalCaddyfile(t *testing.T) {
 				"region": "us-east-1",
 			},
 		},
+		{
+			name: "test valid config with version stage and id",
+			d:    caddyfile.NewTestDispenser(testCfg9),
+			want: map[string]interface{}{
+				"id":            "access_token",
+				"path":          "authcrunch/caddy/access_token",
+				"region":        "us-east-1",
+				"version_stage": "AWSPREVIOUS",
+				"version_id":    "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE",
+			},
+		},
+		{
+			name:      "test invalid version stage value",
+			d:         caddyfile.NewTestDispenser(testCfg10),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: field %q of %q secret with value of %q has invalid syntax",
+				5, "version_stage", "", []string{"AWSPREVIOUS", "AWSPENDING"},
+			),
+		},
+		{
+			name: "test valid config with binary format",
+			d:    caddyfile.NewTestDispenser(testCfg11),
+			want: map[string]interface{}{
+				"id":        "keystore",
+				"path":      "authcrunch/caddy/keystore",
+				"region":    "us-east-1",
+				"format":    "binary",
+				"value_key": "jks",
+				"encoding":  "base64",
+			},
+		},
+		{
+			name:      "test config with unsupported encoding",
+			d:         caddyfile.NewTestDispenser(testCfg12),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has unsupported %q encoding", 7, "keystore", "base32"),
+		},
+		{
+			name: "test valid config with key aliases and projection",
+			d:    caddyfile.NewTestDispenser(testCfg13),
+			want: map[string]interface{}{
+				"id":     "users/jsmith",
+				"path":   "shared/users/jsmith",
+				"region": "us-east-1",
+				"aliases": map[string]interface{}{
+					"username": "login",
+					"password": "pass",
+				},
+				"only": []interface{}{"username", "password", "email"},
+			},
+		},
+		{
+			name:      "test config with duplicate key alias",
+			d:         caddyfile.NewTestDispenser(testCfg14),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: field %q of %q secret has duplicate %q alias", 6, "key", "", "username"),
+		},
+		{
+			name:      "test config with invalid key alias",
+			d:         caddyfile.NewTestDispenser(testCfg15),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: field %q of %q secret with value of %q has invalid syntax",
+				5, "key", "", []string{"username"},
+			),
+		},
+		{
+			name: "test valid config with required keys",
+			d:    caddyfile.NewTestDispenser(testCfg16),
+			want: map[string]interface{}{
+				"id":       "users/jsmith",
+				"path":     "authcrunch/caddy/users/jsmith",
+				"region":   "us-east-1",
+				"required": []interface{}{"username", "password", "email"},
+			},
+		},
+		{
+			name: "test valid config with inline schema",
+			d:    caddyfile.NewTestDispenser(testCfg17),
+			want: map[string]interface{}{
+				"id":     "access_token",
+				"path":   "authcrunch/caddy/access_token",
+				"region": "us-east-1",
+				"schema": map[string]interface{}{
+					"type":     "object",
+					"required": []interface{}{"value"},
+				},
+			},
+		},
+		{
+			name:      "test config with malformed inline schema",
+			d:         caddyfile.NewTestDispenser(testCfg18),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: field %q of %q secret with value of %q has invalid syntax",
+				5, "schema", "", []string{`{"type":`},
+			),
+		},
+		{
+			name: "test valid config with derived keys",
+			d:    caddyfile.NewTestDispenser(testCfg19),
+			want: map[string]interface{}{
+				"id":     "db",
+				"path":   "authcrunch/caddy/db",
+				"region": "us-east-1",
+				"derived": map[string]interface{}{
+					"dsn":        "postgres://{{.user}}:{{.password}}@{{.host}}/app",
+					"basic_auth": "{{.user}}:{{.password}}",
+				},
+			},
+		},
+		{
+			name:      "test config with malformed derived key template",
+			d:         caddyfile.NewTestDispenser(testCfg20),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: secret %q has malformed template of %q derived key: %s",
+				6, "db", "dsn", "template: dsn:1: unclosed action",
+			),
+		},
+		{
+			name: "test valid config with multiple paths",
+			d:    caddyfile.NewTestDispenser(testCfg21),
+			want: map[string]interface{}{
+				"id":           "users/jsmith",
+				"paths":        []interface{}{"users/jsmith/profile", "users/jsmith/credentials"},
+				"region":       "us-east-1",
+				"merge_policy": "last_wins",
+			},
+		},
+		{
+			name:      "test config with unsupported merge policy",
+			d:         caddyfile.NewTestDispenser(testCfg22),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has unsupported %q merge policy", 7, "users/jsmith", "random"),
+		},
+		{
+			name: "test valid config with path prefix",
+			d:    caddyfile.NewTestDispenser(testCfg23),
+			want: map[string]interface{}{
+				"id":          "users",
+				"path_prefix": "authcrunch/caddy/users/",
+				"region":      "us-east-1",
+				"tags": map[string]interface{}{
+					"env":  "prod",
+					"team": "iam",
+				},
+			},
+		},
+		{
+			name:      "test config with path and path prefix",
+			d:         caddyfile.NewTestDispenser(testCfg24),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has both path and path prefix", 6, "users"),
+		},
+		{
+			name: "test valid config with refresh interval",
+			d:    caddyfile.NewTestDispenser(testCfg25),
+			want: map[string]interface{}{
+				"id":               "access_token",
+				"path":             "authcrunch/caddy/access_token",
+				"region":           "us-east-1",
+				"refresh_interval": float64(15 * time.Minute),
+			},
+		},
+		{
+			name:      "test config with malformed refresh interval",
+			d:         caddyfile.NewTestDispenser(testCfg26),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: field %q of %q secret with value of %q has invalid syntax: %v",
+				5, "refresh_interval", "", []string{"soon"}, `time: invalid duration "soon"`,
+			),
+		},
+		{
+			name: "test valid config with cache policy",
+			d:    caddyfile.NewTestDispenser(testCfg27),
+			want: map[string]interface{}{
+				"id":                     "access_token",
+				"path":                   "authcrunch/caddy/access_token",
+				"region":                 "us-east-1",
+				"cache_ttl":              float64(5 * time.Minute),
+				"max_stale":              float64(time.Hour),
+				"stale_on_error":         true,
+				"stale_while_revalidate": true,
+			},
+		},
+		{
+			name:      "test config with stale policy without cache ttl",
+			d:         caddyfile.NewTestDispenser(testCfg28),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has stale cache policy, but it has no cache ttl", 6, "access_token"),
+		},
+		{
+			name:      "test config with max stale without stale policy",
+			d:         caddyfile.NewTestDispenser(testCfg53),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has max stale, but it has neither stale on error nor stale while revalidate", 7, "access_token"),
+		},
+		{
+			name: "test valid config with rotation tracking",
+			d:    caddyfile.NewTestDispenser(testCfg29),
+			want: map[string]interface{}{
+				"id":               "access_token",
+				"path":             "authcrunch/caddy/access_token",
+				"region":           "us-east-1",
+				"refresh_interval": float64(time.Minute),
+				"track_rotation":   true,
+			},
+		},
+		{
+			name:      "test config with rotation tracking without refresh",
+			d:         caddyfile.NewTestDispenser(testCfg30),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: secret %q has rotation tracking, but it has neither refresh interval nor cache ttl",
+				6, "access_token",
+			),
+		},
+		{
+			name: "test valid config with miss policy",
+			d:    caddyfile.NewTestDispenser(testCfg31),
+			want: map[string]interface{}{
+				"id":                 "access_token",
+				"path":               "authcrunch/caddy/access_token",
+				"region":             "us-east-1",
+				"miss_policy":        "refetch_once",
+				"negative_cache_ttl": float64(5 * time.Minute),
+			},
+		},
+		{
+			name:      "test config with unsupported miss policy",
+			d:         caddyfile.NewTestDispenser(testCfg32),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has unsupported %q miss policy", 6, "access_token", "ignore"),
+		},
+		{
+			name: "test valid config with cache file",
+			d:    caddyfile.NewTestDispenser(testCfg33),
+			want: map[string]interface{}{
+				"id":                 "access_token",
+				"path":               "authcrunch/caddy/access_token",
+				"region":             "us-east-1",
+				"cache_file":         "/var/lib/caddy/access_token.cache",
+				"cache_key_env":      "AUTHCRUNCH_CACHE_KEY",
+				"cache_file_max_age": float64(24 * time.Hour),
+			},
+		},
+		{
+			name:      "test config with cache file without cache key",
+			d:         caddyfile.NewTestDispenser(testCfg34),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has cache file, but it has no cache key", 6, "access_token"),
+		},
+		{
+			name: "test valid config with lazy load",
+			d:    caddyfile.NewTestDispenser(testCfg35),
+			want: map[string]interface{}{
+				"id":     "access_token",
+				"path":   "authcrunch/caddy/access_token",
+				"region": "us-east-1",
+				"load":   "lazy",
+			},
+		},
+		{
+			name:      "test config with unsupported load mode",
+			d:         caddyfile.NewTestDispenser(testCfg36),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has unsupported %q load mode", 6, "access_token", "never"),
+		},
+		{
+			name: "test valid config with endpoint",
+			d:    caddyfile.NewTestDispenser(testCfg37),
+			want: map[string]interface{}{
+				"id":       "access_token",
+				"path":     "authcrunch/caddy/access_token",
+				"region":   "us-east-1",
+				"endpoint": "http://localhost:4566",
+			},
+		},
+		{
+			name: "test valid config with fips and dualstack endpoint",
+			d:    caddyfile.NewTestDispenser(testCfg38),
+			want: map[string]interface{}{
+				"id":            "access_token",
+				"path":          "authcrunch/caddy/access_token",
+				"region":        "us-east-1",
+				"use_fips":      true,
+				"use_dualstack": true,
+			},
+		},
+		{
+			name:      "test config with malformed endpoint",
+			d:         caddyfile.NewTestDispenser(testCfg39),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has malformed %q endpoint", 6, "access_token", "localhost:4566"),
+		},
+		{
+			name:      "test config with endpoint and fips",
+			d:         caddyfile.NewTestDispenser(testCfg40),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has endpoint, but it also has fips or dualstack endpoint toggle", 7, "access_token"),
+		},
+		{
+			name: "test valid config with assume role",
+			d:    caddyfile.NewTestDispenser(testCfg41),
+			want: map[string]interface{}{
+				"id":               "access_token",
+				"path":             "authcrunch/caddy/access_token",
+				"region":           "us-east-1",
+				"role_arn":         "arn:aws:iam::123456789012:role/caddy-secrets",
+				"external_id":      "b6c2c4f0",
+				"session_name":     "caddy-access-token",
+				"session_duration": float64(time.Hour),
+				"source_profile":   "ops",
+			},
+		},
+		{
+			name:      "test config with malformed role arn",
+			d:         caddyfile.NewTestDispenser(testCfg42),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has malformed %q role arn", 6, "access_token", "arn:aws:iam::123:role/caddy"),
+		},
+		{
+			name:      "test config with external id without role arn",
+			d:         caddyfile.NewTestDispenser(testCfg43),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has assume role settings, but it has no role arn", 6, "access_token"),
+		},
+		{
+			name: "test valid config with named profile",
+			d:    caddyfile.NewTestDispenser(testCfg45),
+			want: map[string]interface{}{
+				"id":                      "access_token",
+				"path":                    "authcrunch/caddy/access_token",
+				"region":                  "us-east-1",
+				"profile":                 "caddy",
+				"shared_config_file":      "/etc/caddy/aws/config",
+				"shared_credentials_file": "/etc/caddy/aws/credentials",
+			},
+		},
+		{
+			name:      "test config with invalid profile value",
+			d:         caddyfile.NewTestDispenser(testCfg46),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: field %q of %q secret with value of %q has invalid syntax",
+				5, "profile", "", []string{"caddy", "ops"},
+			),
+		},
+		{
+			name: "test valid config with web identity",
+			d:    caddyfile.NewTestDispenser(testCfg47),
+			want: map[string]interface{}{
+				"id":                      "access_token",
+				"path":                    "authcrunch/caddy/access_token",
+				"region":                  "us-east-1",
+				"role_arn":                "arn:aws:iam::123456789012:role/caddy-secrets",
+				"web_identity_token_file": "/var/run/secrets/caddy/token",
+				"sts_endpoint":            "http://localhost:4566",
+			},
+		},
+		{
+			name: "test valid config with regions",
+			d:    caddyfile.NewTestDispenser(testCfg48),
+			want: map[string]interface{}{
+				"id":      "access_token",
+				"path":    "authcrunch/caddy/access_token",
+				"regions": []interface{}{"us-east-1", "us-west-2"},
+			},
+		},
+		{
+			name: "test valid config with failover region",
+			d:    caddyfile.NewTestDispenser(testCfg49),
+			want: map[string]interface{}{
+				"id":              "access_token",
+				"path":            "authcrunch/caddy/access_token",
+				"region":          "us-east-1",
+				"failover_region": "us-west-2",
+			},
+		},
+		{
+			name:      "test config with regions and failover region",
+			d:         caddyfile.NewTestDispenser(testCfg50),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has both regions and failover region", 6, "access_token"),
+		},
+		{
+			name: "test valid config with arn path",
+			d:    caddyfile.NewTestDispenser(testCfg51),
+			want: map[string]interface{}{
+				"id":               "access_token",
+				"path":             "arn:aws:secretsmanager:us-west-2:123456789012:secret:authcrunch/caddy/access_token-AbCdEf",
+				"expected_account": "123456789012",
+			},
+		},
+		{
+			name:      "test config with arn path in conflicting region",
+			d:         caddyfile.NewTestDispenser(testCfg52),
+			shouldErr: true,
+			err: fmt.Errorf(
+				"Testfile:%d - Error during parsing: secret %q has %q region, but its %q arn is in %q region",
+				5, "access_token", "us-east-1", "arn:aws:secretsmanager:us-west-2:123456789012:secret:authcrunch/caddy/access_token-AbCdEf", "us-west-2",
+			),
+		},
+		{
+			name:      "test config with session duration out of range",
+			d:         caddyfile.NewTestDispenser(testCfg44),
+			shouldErr: true,
+			err:       fmt.Errorf("Testfile:%d - Error during parsing: secret %q has session duration %v out of %v to %v range", 7, "access_token", 5*time.Minute, 15*time.Minute, 12*time.Hour),
+		},
 		{
 			name:      "test config without path",
 			d:         caddyfile.NewTestDispenser(testCfg2),
@@ -188,3 +601,392 @@ access_token {
 var testCfg8 = `
 
 `
+
+var testCfg9 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	version_stage AWSPREVIOUS
+	version_id EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE
+}
+`
+
+var testCfg10 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	version_stage AWSPREVIOUS AWSPENDING
+}
+`
+
+var testCfg11 = `
+keystore {
+	region us-east-1
+	path authcrunch/caddy/keystore
+	format binary
+	value_key jks
+	encoding base64
+}
+`
+
+var testCfg12 = `
+keystore {
+	region us-east-1
+	path authcrunch/caddy/keystore
+	format binary
+	encoding base32
+}
+`
+
+var testCfg13 = `
+users/jsmith {
+	region us-east-1
+	path shared/users/jsmith
+	key username login
+	key password pass
+	only username password
+	only email
+}
+`
+
+var testCfg14 = `
+users/jsmith {
+	region us-east-1
+	path shared/users/jsmith
+	key username login
+	key username user
+}
+`
+
+var testCfg15 = `
+users/jsmith {
+	region us-east-1
+	path shared/users/jsmith
+	key username
+}
+`
+
+var testCfg16 = `
+users/jsmith {
+	region us-east-1
+	path authcrunch/caddy/users/jsmith
+	require username password
+	require email
+}
+`
+
+var testCfg17 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	schema ` + "`" + `{"type": "object", "required": ["value"]}` + "`" + `
+}
+`
+
+var testCfg18 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	schema ` + "`" + `{"type":` + "`" + `
+}
+`
+
+var testCfg19 = `
+db {
+	region us-east-1
+	path authcrunch/caddy/db
+	derive dsn "postgres://{{.user}}:{{.password}}@{{.host}}/app"
+	derive basic_auth {{.user}}:{{.password}}
+}
+`
+
+var testCfg20 = `
+db {
+	region us-east-1
+	path authcrunch/caddy/db
+	derive dsn "{{.user"
+}
+`
+
+var testCfg21 = `
+users/jsmith {
+	region us-east-1
+	path users/jsmith/profile
+	path users/jsmith/credentials
+	merge_policy last_wins
+}
+`
+
+var testCfg22 = `
+users/jsmith {
+	region us-east-1
+	path users/jsmith/profile
+	path users/jsmith/credentials
+	merge_policy random
+}
+`
+
+var testCfg23 = `
+users {
+	region us-east-1
+	path_prefix authcrunch/caddy/users/
+	tag env prod
+	tag team iam
+}
+`
+
+var testCfg24 = `
+users {
+	region us-east-1
+	path authcrunch/caddy/users/jsmith
+	path_prefix authcrunch/caddy/users/
+}
+`
+
+var testCfg25 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	refresh_interval 15m
+}
+`
+
+var testCfg26 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	refresh_interval soon
+}
+`
+
+var testCfg27 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	cache_ttl 5m
+	max_stale 1h
+	stale_on_error
+	stale_while_revalidate
+}
+`
+
+var testCfg28 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	stale_on_error
+}
+`
+
+var testCfg29 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	refresh_interval 1m
+	track_rotation
+}
+`
+
+var testCfg30 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	track_rotation
+}
+`
+
+var testCfg31 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	miss_policy refetch_once
+	negative_cache_ttl 5m
+}
+`
+
+var testCfg32 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	miss_policy ignore
+}
+`
+
+var testCfg33 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	cache_file /var/lib/caddy/access_token.cache
+	cache_key_env AUTHCRUNCH_CACHE_KEY
+	cache_file_max_age 24h
+}
+`
+
+var testCfg34 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	cache_file /var/lib/caddy/access_token.cache
+}
+`
+
+var testCfg35 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	load lazy
+}
+`
+
+var testCfg36 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	load never
+}
+`
+
+var testCfg37 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	endpoint http://localhost:4566
+}
+`
+
+var testCfg38 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	use_fips
+	use_dualstack
+}
+`
+
+var testCfg39 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	endpoint localhost:4566
+}
+`
+
+var testCfg40 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	endpoint https://vpce-0123456789abcdef0-abcdefgh.secretsmanager.us-east-1.vpce.amazonaws.com
+	use_fips
+}
+`
+
+var testCfg41 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	role_arn arn:aws:iam::123456789012:role/caddy-secrets
+	external_id b6c2c4f0
+	session_name caddy-access-token
+	session_duration 1h
+	source_profile ops
+}
+`
+
+var testCfg42 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	role_arn arn:aws:iam::123:role/caddy
+}
+`
+
+var testCfg43 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	external_id b6c2c4f0
+}
+`
+
+var testCfg44 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	role_arn arn:aws:iam::123456789012:role/caddy-secrets
+	session_duration 5m
+}
+`
+
+var testCfg45 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	profile caddy
+	shared_config_file /etc/caddy/aws/config
+	shared_credentials_file /etc/caddy/aws/credentials
+}
+`
+
+var testCfg46 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	profile caddy ops
+}
+`
+
+var testCfg47 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	role_arn arn:aws:iam::123456789012:role/caddy-secrets
+	web_identity_token_file /var/run/secrets/caddy/token
+	sts_endpoint http://localhost:4566
+}
+`
+
+var testCfg48 = `
+access_token {
+	regions us-east-1 us-west-2
+	path authcrunch/caddy/access_token
+}
+`
+
+var testCfg49 = `
+access_token {
+	region us-east-1
+	failover_region us-west-2
+	path authcrunch/caddy/access_token
+}
+`
+
+var testCfg50 = `
+access_token {
+	regions us-east-1 us-west-2
+	failover_region eu-west-1
+	path authcrunch/caddy/access_token
+}
+`
+
+var testCfg51 = `
+access_token {
+	path arn:aws:secretsmanager:us-west-2:123456789012:secret:authcrunch/caddy/access_token-AbCdEf
+	expected_account 123456789012
+}
+`
+
+var testCfg52 = `
+access_token {
+	region us-east-1
+	path arn:aws:secretsmanager:us-west-2:123456789012:secret:authcrunch/caddy/access_token-AbCdEf
+}
+`
+
+var testCfg53 = `
+access_token {
+	region us-east-1
+	path authcrunch/caddy/access_token
+	cache_ttl 5m
+	max_stale 1h
+}
+`
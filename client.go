@@ -0,0 +1,360 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+var (
+	awsRegionRgx = regexp.MustCompile(`\w{2}-\w+-\d`)
+)
+
+const (
+	defaultVersionStage = "AWSCURRENT"
+)
+
+type clientConfig struct {
+	ID           string `json:"id,omitempty" xml:"id,omitempty" yaml:"id,omitempty"`
+	Region       string `json:"region,omitempty" xml:"region,omitempty" yaml:"region,omitempty"`
+	Provider     string `json:"provider,omitempty" xml:"provider,omitempty" yaml:"provider,omitempty"`
+	Endpoint     string `json:"endpoint,omitempty" xml:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	UseFIPS      bool   `json:"use_fips,omitempty" xml:"use_fips,omitempty" yaml:"use_fips,omitempty"`
+	UseDualStack bool   `json:"use_dualstack,omitempty" xml:"use_dualstack,omitempty" yaml:"use_dualstack,omitempty"`
+
+	Profile               string `json:"profile,omitempty" xml:"profile,omitempty" yaml:"profile,omitempty"`
+	SharedConfigFile      string `json:"shared_config_file,omitempty" xml:"shared_config_file,omitempty" yaml:"shared_config_file,omitempty"`
+	SharedCredentialsFile string `json:"shared_credentials_file,omitempty" xml:"shared_credentials_file,omitempty" yaml:"shared_credentials_file,omitempty"`
+
+	RoleARN         string        `json:"role_arn,omitempty" xml:"role_arn,omitempty" yaml:"role_arn,omitempty"`
+	ExternalID      string        `json:"-" xml:"-" yaml:"-"`
+	SessionName     string        `json:"session_name,omitempty" xml:"session_name,omitempty" yaml:"session_name,omitempty"`
+	SessionDuration time.Duration `json:"session_duration,omitempty" xml:"session_duration,omitempty" yaml:"session_duration,omitempty"`
+	SourceProfile   string        `json:"source_profile,omitempty" xml:"source_profile,omitempty" yaml:"source_profile,omitempty"`
+
+	WebIdentityTokenFile string `json:"web_identity_token_file,omitempty" xml:"web_identity_token_file,omitempty" yaml:"web_identity_token_file,omitempty"`
+	STSEndpoint          string `json:"sts_endpoint,omitempty" xml:"sts_endpoint,omitempty" yaml:"sts_endpoint,omitempty"`
+}
+
+// poolKey returns the key of the AWS SDK client in the pool. The clients
+// having the same key are interchangeable.
+func (c *clientConfig) poolKey() string {
+	return strings.Join([]string{
+		c.Region,
+		c.Endpoint,
+		strconv.FormatBool(c.UseFIPS),
+		strconv.FormatBool(c.UseDualStack),
+		c.Profile,
+		c.SharedConfigFile,
+		c.SharedCredentialsFile,
+		c.RoleARN,
+		c.ExternalID,
+		c.SessionName,
+		c.SessionDuration.String(),
+		c.SourceProfile,
+		c.WebIdentityTokenFile,
+		c.STSEndpoint,
+	}, "|")
+}
+
+// secretRequest identifies a version of a secret in AWS Secrets Manager.
+type secretRequest struct {
+	Path         string
+	VersionID    string
+	VersionStage string
+}
+
+// client is AWS Secrets Manager client.
+type client struct {
+	config  *clientConfig
+	service *clientService
+}
+
+// clientService is the AWS SDK client of AWS Secrets Manager. It may be
+// shared by multiple clients having the same settings. The health of its
+// region is shared along with it.
+type clientService struct {
+	config       aws.Config
+	sourceConfig aws.Config
+	settings     *clientConfig
+	client       *secretsmanager.Client
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+}
+
+// newClient returns an instance of AWS Secrets Manager client.
+func newClient(ctx context.Context, cfg *clientConfig) (*client, error) {
+	service, err := newClientService(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newClientWithService(cfg, service), nil
+}
+
+// newClientWithService returns an instance of AWS Secrets Manager client
+// using the provided AWS SDK client.
+func newClientWithService(cfg *clientConfig, service *clientService) *client {
+	c := &client{
+		config:  cfg,
+		service: service,
+	}
+	if c.config.Provider == "" {
+		c.config.Provider = "aws_secrets_manager"
+	}
+	return c
+}
+
+// newClientService returns an instance of AWS SDK client of AWS Secrets
+// Manager.
+func newClientService(ctx context.Context, cfg *clientConfig) (*clientService, error) {
+	if cfg.Region != "" {
+		if !awsRegionRgx.MatchString(cfg.Region) {
+			return nil, fmt.Errorf("malformed %q region", cfg.Region)
+		}
+	}
+
+	sharedOpts, err := cfg.sharedConfigOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.Region),
+	}
+	opts = append(opts, sharedOpts...)
+	if cfg.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(cfg.Profile))
+	}
+	if cfg.UseFIPS {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if cfg.UseDualStack {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+
+	serviceConfig, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := &clientService{
+		config:       serviceConfig,
+		sourceConfig: serviceConfig,
+		settings:     cfg,
+	}
+	if cfg.SourceProfile != "" {
+		sourceOpts := []func(*config.LoadOptions) error{
+			config.WithRegion(cfg.Region),
+			config.WithSharedConfigProfile(cfg.SourceProfile),
+		}
+		sourceConfig, err := config.LoadDefaultConfig(ctx, append(sourceOpts, sharedOpts...)...)
+		if err != nil {
+			return nil, err
+		}
+		s.sourceConfig = sourceConfig
+	}
+	s.client = s.newServiceClient()
+	return s, nil
+}
+
+// sharedConfigOptions returns the options loading the shared configuration
+// and credentials from the configured files instead of the default ones. The
+// AWS SDK skips the shared files that do not exist, so a missing file is
+// reported here rather than ignored.
+func (c *clientConfig) sharedConfigOptions() ([]func(*config.LoadOptions) error, error) {
+	var opts []func(*config.LoadOptions) error
+	if c.SharedConfigFile != "" {
+		if _, err := os.Stat(c.SharedConfigFile); err != nil {
+			return nil, fmt.Errorf("unreadable shared config file: %v", err)
+		}
+		opts = append(opts, config.WithSharedConfigFiles([]string{c.SharedConfigFile}))
+	}
+	if c.SharedCredentialsFile != "" {
+		if _, err := os.Stat(c.SharedCredentialsFile); err != nil {
+			return nil, fmt.Errorf("unreadable shared credentials file: %v", err)
+		}
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{c.SharedCredentialsFile}))
+	}
+	return opts, nil
+}
+
+// newServiceClient returns the AWS SDK client. With a role to assume, the
+// client uses the credentials of the role, obtained with the credentials of
+// the source configuration.
+func (s *clientService) newServiceClient() *secretsmanager.Client {
+	cfg := s.config.Copy()
+	if s.settings.RoleARN != "" {
+		cfg.Credentials = newAssumeRoleCredentials(s.settings, s.sourceConfig)
+	}
+	return secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+		if s.settings.Endpoint != "" {
+			o.EndpointResolver = secretsmanager.EndpointResolverFromURL(s.settings.Endpoint)
+		}
+	})
+}
+
+// Destruct implements caddy.Destructor.
+func (s *clientService) Destruct() error {
+	return nil
+}
+
+func (c *client) getServiceClient() *secretsmanager.Client {
+	return c.service.client
+}
+
+// GetSecretValue returns the value of the requested version of a secret.
+func (c *client) GetSecretValue(ctx context.Context, req *secretRequest) (*secretsmanager.GetSecretValueOutput, error) {
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(req.Path),
+	}
+	if req.VersionID != "" {
+		input.VersionId = aws.String(req.VersionID)
+	}
+	switch {
+	case req.VersionStage != "":
+		input.VersionStage = aws.String(req.VersionStage)
+	case req.VersionID == "":
+		input.VersionStage = aws.String(defaultVersionStage)
+	}
+	return c.getServiceClient().GetSecretValue(ctx, input)
+}
+
+// GetVersionID returns the ID of the version of a secret having the version
+// stage. It does not retrieve the value of the secret.
+func (c *client) GetVersionID(ctx context.Context, path, stage string) (string, error) {
+	output, err := c.getServiceClient().DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(path),
+	})
+	if err != nil {
+		return "", err
+	}
+	for versionID, stages := range output.VersionIdsToStages {
+		for _, s := range stages {
+			if s == stage {
+				return versionID, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("version stage %q of %q secret not found", stage, path)
+}
+
+// ListSecrets returns the sorted names of the secrets starting with the
+// prefix and having all of the tags.
+func (c *client) ListSecrets(ctx context.Context, prefix string, tags map[string]string) ([]string, error) {
+	input := &secretsmanager.ListSecretsInput{
+		Filters: []types.Filter{
+			{
+				Key:    types.FilterNameStringTypeName,
+				Values: []string{prefix},
+			},
+		},
+	}
+	for k := range tags {
+		input.Filters = append(input.Filters, types.Filter{
+			Key:    types.FilterNameStringTypeTagKey,
+			Values: []string{k},
+		})
+	}
+
+	var names []string
+	paginator := secretsmanager.NewListSecretsPaginator(c.getServiceClient(), input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range output.SecretList {
+			name := aws.ToString(entry.Name)
+			// The name filter of AWS Secrets Manager is case-insensitive.
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if !hasTags(entry.Tags, tags) {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func hasTags(entryTags []types.Tag, tags map[string]string) bool {
+	for k, v := range tags {
+		var found bool
+		for _, tag := range entryTags {
+			if aws.ToString(tag.Key) == k && aws.ToString(tag.Value) == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SetMockClient replaces the HTTP client used to talk to AWS.
+func (c *client) SetMockClient(mockClient aws.HTTPClient) {
+	c.service.config.HTTPClient = mockClient
+	c.service.sourceConfig.HTTPClient = mockClient
+	c.service.client = c.service.newServiceClient()
+}
+
+// SetMockCredentialsProvider replaces the credentials provider.
+func (c *client) SetMockCredentialsProvider(mockProvider aws.CredentialsProvider) {
+	c.service.config.Credentials = mockProvider
+	c.service.sourceConfig.Credentials = mockProvider
+	c.service.client = c.service.newServiceClient()
+}
+
+// GetConfig returns client configuration.
+func (c *client) GetConfig(_ context.Context) map[string]interface{} {
+	cfg := map[string]interface{}{
+		"id":       c.config.ID,
+		"region":   c.config.Region,
+		"provider": c.config.Provider,
+	}
+	if c.config.Endpoint != "" {
+		cfg["endpoint"] = c.config.Endpoint
+	}
+	if c.config.UseFIPS {
+		cfg["use_fips"] = true
+	}
+	if c.config.UseDualStack {
+		cfg["use_dualstack"] = true
+	}
+	if c.config.Profile != "" {
+		cfg["profile"] = c.config.Profile
+	}
+	if c.config.RoleARN != "" {
+		cfg["role_arn"] = c.config.RoleARN
+	}
+	return cfg
+}
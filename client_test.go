@@ -0,0 +1,333 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+// newMockRequestRecorder returns a mock HTTP client responding with the
+// provided secret and recording the JSON body of every request.
+func newMockRequestRecorder(t *testing.T, secret map[string]interface{}, requests *[]map[string]interface{}) aws.HTTPClient {
+	return smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed reading request body: %v", err)
+		}
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("failed parsing request body %q: %v", b, err)
+		}
+		*requests = append(*requests, m)
+		response := packMapToJSON(t, map[string]interface{}{
+			"SecretString": packMapToJSON(t, secret),
+		})
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(response)),
+		}, nil
+	})
+}
+
+// newMockSecretStore returns a mock HTTP client responding with the secret
+// stored at the requested path.
+func newMockSecretStore(t *testing.T, secrets map[string]map[string]interface{}) aws.HTTPClient {
+	return smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed reading request body: %v", err)
+		}
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("failed parsing request body %q: %v", b, err)
+		}
+		path, _ := m["SecretId"].(string)
+		secret, exists := secrets[path]
+		if !exists {
+			response := packMapToJSON(t, map[string]interface{}{
+				"__type":  "ResourceNotFoundException",
+				"Message": "Secrets Manager can't find the specified secret.",
+			})
+			return &http.Response{
+				StatusCode: 400,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader(response)),
+			}, nil
+		}
+		response := packMapToJSON(t, map[string]interface{}{
+			"SecretString": packMapToJSON(t, secret),
+		})
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(response)),
+		}, nil
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	testcases := []struct {
+		name      string
+		region    string
+		want      map[string]interface{}
+		shouldErr bool
+		err       error
+	}{
+		{
+			name:   "test new client with valid region",
+			region: "us-east-1",
+			want: map[string]interface{}{
+				"id":       "foo",
+				"region":   "us-east-1",
+				"provider": "aws_secrets_manager",
+			},
+		},
+		{
+			name:      "test new client with malformed region",
+			region:    "foo-bar-baz",
+			shouldErr: true,
+			err:       fmt.Errorf("malformed %q region", "foo-bar-baz"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newClient(context.TODO(), &clientConfig{ID: "foo", Region: tc.region})
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Logf("unexpected error: %v", err)
+					t.Fatalf("newClient() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+
+			got := c.GetConfig(context.TODO())
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("newClient() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientSecretVersion(t *testing.T) {
+	testcases := []struct {
+		name string
+		req  *secretRequest
+		want map[string]interface{}
+	}{
+		{
+			name: "test default version stage",
+			req:  &secretRequest{Path: "foo/bar"},
+			want: map[string]interface{}{
+				"SecretId":     "foo/bar",
+				"VersionStage": "AWSCURRENT",
+			},
+		},
+		{
+			name: "test custom version stage",
+			req:  &secretRequest{Path: "foo/bar", VersionStage: "AWSPREVIOUS"},
+			want: map[string]interface{}{
+				"SecretId":     "foo/bar",
+				"VersionStage": "AWSPREVIOUS",
+			},
+		},
+		{
+			name: "test version id",
+			req:  &secretRequest{Path: "foo/bar", VersionID: "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE"},
+			want: map[string]interface{}{
+				"SecretId":  "foo/bar",
+				"VersionId": "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE",
+			},
+		},
+		{
+			name: "test version id with version stage",
+			req:  &secretRequest{Path: "foo/bar", VersionID: "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE", VersionStage: "AWSPENDING"},
+			want: map[string]interface{}{
+				"SecretId":     "foo/bar",
+				"VersionId":    "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE",
+				"VersionStage": "AWSPENDING",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newClient(context.TODO(), &clientConfig{ID: "foo", Region: "us-east-1"})
+			if err != nil {
+				t.Fatalf("unexpected error during client initialization: %v", err)
+			}
+
+			var requests []map[string]interface{}
+			c.SetMockClient(newMockRequestRecorder(t, map[string]interface{}{"foo": "bar"}, &requests))
+			c.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+			if _, err := c.GetSecretValue(context.TODO(), tc.req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(requests) != 1 {
+				t.Fatalf("unexpected number of requests: %d", len(requests))
+			}
+
+			if diff := cmp.Diff(tc.want, requests[0]); diff != "" {
+				t.Errorf("GetSecretValue() request mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClientEndpoint(t *testing.T) {
+	testcases := []struct {
+		name string
+		cfg  *clientConfig
+		want string
+	}{
+		{
+			name: "test default endpoint",
+			cfg:  &clientConfig{ID: "foo", Region: "us-east-1"},
+			want: "https://secretsmanager.us-east-1.amazonaws.com/",
+		},
+		{
+			name: "test custom endpoint",
+			cfg:  &clientConfig{ID: "foo", Region: "us-east-1", Endpoint: "http://localhost:4566"},
+			want: "http://localhost:4566/",
+		},
+		{
+			name: "test fips endpoint",
+			cfg:  &clientConfig{ID: "foo", Region: "us-east-1", UseFIPS: true},
+			want: "https://secretsmanager-fips.us-east-1.amazonaws.com/",
+		},
+		{
+			name: "test dualstack endpoint",
+			cfg:  &clientConfig{ID: "foo", Region: "us-east-1", UseDualStack: true},
+			want: "https://secretsmanager.us-east-1.api.aws/",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newClient(context.TODO(), tc.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error during client initialization: %v", err)
+			}
+
+			var got string
+			var requests []map[string]interface{}
+			recorder := newMockRequestRecorder(t, map[string]interface{}{"foo": "bar"}, &requests)
+			c.SetMockClient(smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
+				got = r.URL.String()
+				return recorder.Do(r)
+			}))
+			c.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+			if _, err := c.GetSecretValue(context.TODO(), &secretRequest{Path: "foo/bar"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("GetSecretValue() endpoint mismatch: want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClientSharedConfig(t *testing.T) {
+	for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+		t.Setenv(k, "")
+	}
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(configFile, []byte("[profile caddy]\nregion = us-west-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	credentials := "[default]\naws_access_key_id = AKIADEFAULT\naws_secret_access_key = secret\n\n" +
+		"[caddy]\naws_access_key_id = AKIACADDY\naws_secret_access_key = secret\n"
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name      string
+		cfg       *clientConfig
+		want      string
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test default profile of shared credentials file",
+			cfg:  &clientConfig{ID: "foo", Region: "us-east-1", SharedConfigFile: configFile, SharedCredentialsFile: credentialsFile},
+			want: "AKIADEFAULT",
+		},
+		{
+			name: "test named profile of shared credentials file",
+			cfg:  &clientConfig{ID: "foo", Region: "us-east-1", Profile: "caddy", SharedConfigFile: configFile, SharedCredentialsFile: credentialsFile},
+			want: "AKIACADDY",
+		},
+		{
+			name:      "test missing shared credentials file",
+			cfg:       &clientConfig{ID: "foo", Region: "us-east-1", SharedCredentialsFile: filepath.Join(dir, "missing")},
+			shouldErr: true,
+			err:       fmt.Errorf("unreadable shared credentials file: stat %s: no such file or directory", filepath.Join(dir, "missing")),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newClient(context.TODO(), tc.cfg)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("unexpected error during client initialization: %v", err)
+				}
+				if diff := cmp.Diff(tc.err.Error(), err.Error()); diff != "" {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+
+			var got string
+			var requests []map[string]interface{}
+			recorder := newMockRequestRecorder(t, map[string]interface{}{"foo": "bar"}, &requests)
+			c.SetMockClient(smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
+				got = parseAccessKey(r.Header.Get("Authorization"))
+				return recorder.Do(r)
+			}))
+
+			if _, err := c.GetSecretValue(context.TODO(), &secretRequest{Path: "foo/bar"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("GetSecretValue() access key mismatch: want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
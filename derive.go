@@ -0,0 +1,73 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var derivedKeyFuncs = template.FuncMap{
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"pathescape":  url.PathEscape,
+	"queryescape": url.QueryEscape,
+}
+
+// derivedKey is a key computed from the other keys of a secret.
+type derivedKey struct {
+	key  string
+	tmpl *template.Template
+}
+
+// compileDerivedKeys parses the templates of the derived keys.
+func compileDerivedKeys(cfg *Config) ([]*derivedKey, error) {
+	keys := make([]string, 0, len(cfg.Derived))
+	for k := range cfg.Derived {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var derived []*derivedKey
+	for _, k := range keys {
+		if k == "" {
+			return nil, fmt.Errorf("secret %q has empty derived key", cfg.ID)
+		}
+		tmpl, err := template.New(k).Funcs(derivedKeyFuncs).Option("missingkey=error").Parse(cfg.Derived[k])
+		if err != nil {
+			return nil, fmt.Errorf("secret %q has malformed template of %q derived key: %v", cfg.ID, k, err)
+		}
+		derived = append(derived, &derivedKey{key: k, tmpl: tmpl})
+	}
+	return derived, nil
+}
+
+// deriveKeys renders the derived keys over the keys of the secret.
+func deriveKeys(cfg *Config, derived []*derivedKey, secret map[string]interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for _, dk := range derived {
+		var sb strings.Builder
+		if err := dk.tmpl.Execute(&sb, secret); err != nil {
+			return nil, fmt.Errorf("failed deriving %q key of %q secret: %v", dk.key, cfg.ID, err)
+		}
+		m[dk.key] = sb.String()
+	}
+	return m, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// validateDiscovery validates the secret discovery settings of the
+// configuration.
+func validateDiscovery(cfg *Config) error {
+	if cfg.PathPrefix == "" {
+		if len(cfg.Tags) > 0 {
+			return fmt.Errorf("secret %q has tag filters, but it has no path prefix", cfg.ID)
+		}
+		return nil
+	}
+	if cfg.Path != "" || len(cfg.Paths) > 0 {
+		return fmt.Errorf("secret %q has both path and path prefix", cfg.ID)
+	}
+	if cfg.VersionID != "" {
+		return fmt.Errorf("secret %q has version id, but it has path prefix", cfg.ID)
+	}
+	for k := range cfg.Tags {
+		if k == "" {
+			return fmt.Errorf("secret %q has empty tag key", cfg.ID)
+		}
+	}
+	return nil
+}
+
+// getDiscoveredID returns the ID of a secret discovered under the path
+// prefix. The ID is the part of the name of the secret following the prefix,
+// prepended with the ID of the configuration, e.g. the "users/jsmith" ID for
+// the "authcrunch/caddy/users/jsmith" secret under the "authcrunch/caddy/users/"
+// prefix of the "users" configuration. A secret named after the prefix
+// itself has no ID.
+func getDiscoveredID(cfg *Config, name string) (string, bool) {
+	suffix := strings.TrimPrefix(strings.TrimPrefix(name, cfg.PathPrefix), "/")
+	if suffix == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(cfg.ID, "/") + "/" + suffix, true
+}
+
+// fetchDiscoveredSecrets retrieves the secrets under the path prefix. The
+// secrets are keyed by their IDs in the secret of the configuration; they are
+// not registered as separate secrets.
+func (p *Plugin) fetchDiscoveredSecrets(ctx context.Context) (*secretSnapshot, error) {
+	var names []string
+	_, err := p.withFailover(ctx, func(c *client) error {
+		var err error
+		names, err = c.ListSecrets(ctx, p.Config.PathPrefix, p.Config.Tags)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	snapshot := newSecretSnapshot(make(map[string]interface{}), make(map[string]string))
+	for _, name := range names {
+		id, ok := getDiscoveredID(&p.Config, name)
+		if !ok {
+			p.logger.Warn(
+				"skipped secret named after path prefix",
+				zap.String("plugin_name", p.Name),
+				zap.String("secret_id", p.Config.ID),
+				zap.String("path", name),
+			)
+			continue
+		}
+		discovered, err := p.fetchPaths(ctx, []string{name})
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching %q secret: %w", name, err)
+		}
+		snapshot.secret[id] = discovered.secret
+		snapshot.versions[name] = discovered.versions[name]
+		if discovered.fetchedAt.Before(snapshot.fetchedAt) {
+			snapshot.fetchedAt = discovered.fetchedAt
+		}
+	}
+	return snapshot, nil
+}
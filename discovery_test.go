@@ -0,0 +1,197 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+// mockListedSecret is a secret returned by the mock ListSecrets API.
+type mockListedSecret struct {
+	name   string
+	tags   map[string]string
+	secret map[string]interface{}
+}
+
+// newMockSecretLister returns a mock HTTP client serving the ListSecrets API,
+// one secret per page, and the GetSecretValue API.
+func newMockSecretLister(t *testing.T, secrets []*mockListedSecret) aws.HTTPClient {
+	return smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed reading request body: %v", err)
+		}
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("failed parsing request body %q: %v", b, err)
+		}
+
+		var response string
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.ListSecrets":
+			var i int
+			if token, exists := m["NextToken"].(string); exists {
+				fmt.Sscanf(token, "%d", &i)
+			}
+			out := map[string]interface{}{"SecretList": []interface{}{}}
+			if i < len(secrets) {
+				var tags []interface{}
+				for k, v := range secrets[i].tags {
+					tags = append(tags, map[string]interface{}{"Key": k, "Value": v})
+				}
+				out["SecretList"] = []interface{}{
+					map[string]interface{}{"Name": secrets[i].name, "Tags": tags},
+				}
+			}
+			if i+1 < len(secrets) {
+				out["NextToken"] = fmt.Sprintf("%d", i+1)
+			}
+			response = packMapToJSON(t, out)
+		case "secretsmanager.GetSecretValue":
+			for _, s := range secrets {
+				if s.name == m["SecretId"] {
+					response = packMapToJSON(t, map[string]interface{}{
+						"SecretString": packMapToJSON(t, s.secret),
+					})
+				}
+			}
+		}
+		if response == "" {
+			t.Fatalf("unexpected request: %s %s", r.Header.Get("X-Amz-Target"), b)
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(response)),
+		}, nil
+	})
+}
+
+func TestDiscoverSecrets(t *testing.T) {
+	secrets := []*mockListedSecret{
+		{
+			name:   "authcrunch/caddy/users/jsmith",
+			tags:   map[string]string{"env": "prod", "team": "iam"},
+			secret: map[string]interface{}{"username": "jsmith", "password": "foo"},
+		},
+		{
+			name:   "authcrunch/caddy/users/mjones",
+			tags:   map[string]string{"env": "dev"},
+			secret: map[string]interface{}{"username": "mjones", "password": "bar"},
+		},
+		{
+			name:   "AuthCrunch/Caddy/Users/ignored",
+			tags:   map[string]string{"env": "prod"},
+			secret: map[string]interface{}{"username": "ignored"},
+		},
+		{
+			name:   "authcrunch/caddy/users/",
+			tags:   map[string]string{"env": "prod"},
+			secret: map[string]interface{}{"username": "ignored"},
+		},
+		{
+			name:   "authcrunch/caddy/users/ops/bwilson",
+			tags:   map[string]string{"env": "prod"},
+			secret: map[string]interface{}{"username": "bwilson", "password": "baz"},
+		},
+	}
+
+	testcases := []struct {
+		name      string
+		cfg       string
+		want      map[string]interface{}
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test discover secrets under prefix",
+			cfg:  `{"id":"users","path_prefix":"authcrunch/caddy/users/","region":"us-east-1","only":["password"]}`,
+			want: map[string]interface{}{
+				"users/jsmith":      map[string]interface{}{"password": "foo"},
+				"users/mjones":      map[string]interface{}{"password": "bar"},
+				"users/ops/bwilson": map[string]interface{}{"password": "baz"},
+			},
+		},
+		{
+			name: "test discover secrets with tag filters",
+			cfg:  `{"id":"users","path_prefix":"authcrunch/caddy/users/","region":"us-east-1","tags":{"env":"prod"}}`,
+			want: map[string]interface{}{
+				"users/jsmith":      map[string]interface{}{"username": "jsmith", "password": "foo"},
+				"users/ops/bwilson": map[string]interface{}{"username": "bwilson", "password": "baz"},
+			},
+		},
+		{
+			name:      "test discover secrets with missing required keys",
+			cfg:       `{"id":"users","path_prefix":"authcrunch/caddy/users/","region":"us-east-1","required":["password","email"]}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has missing required keys: %s", "users/jsmith", "email"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Plugin{
+				ConfigRaw: json.RawMessage(tc.cfg),
+			}
+			if err := p.Provision(caddy.ActiveContext()); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			p.client.SetMockClient(newMockSecretLister(t, secrets))
+			p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+			err := p.Validate()
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("Validate() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+
+			got, err := p.GetSecret(context.TODO())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+			}
+
+			v, err := p.GetSecretByKey(context.TODO(), "users/jsmith.password")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff("foo", v); diff != "" {
+				t.Errorf("GetSecretByKey() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// validateEndpoint validates the endpoint settings of the configuration.
+func validateEndpoint(cfg *Config) error {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	if !isEndpointURL(cfg.Endpoint) {
+		return fmt.Errorf("secret %q has malformed %q endpoint", cfg.ID, cfg.Endpoint)
+	}
+	if cfg.UseFIPS || cfg.UseDualStack {
+		return fmt.Errorf("secret %q has endpoint, but it also has fips or dualstack endpoint toggle", cfg.ID)
+	}
+	return nil
+}
+
+// isEndpointURL reports whether the string is an absolute HTTP or HTTPS URL.
+func isEndpointURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
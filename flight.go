@@ -0,0 +1,74 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"sync"
+)
+
+const (
+	// flightInit is the key of the calls fetching the secret for the first
+	// time in lazy load mode.
+	flightInit = "init"
+	// flightFetch is the key of the calls fetching the secret on a cache miss.
+	flightFetch = "fetch"
+	// flightReload is the key of the calls replacing an expired or a
+	// refreshed secret.
+	flightReload = "reload"
+)
+
+// flightGroup collapses concurrent calls having the same key into a single
+// call. The callers arriving while the call is in flight wait for it and
+// share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg       sync.WaitGroup
+	snapshot *secretSnapshot
+	err      error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*flightCall),
+	}
+}
+
+// do calls fn, unless a call with the same key is in flight, in which case
+// it waits for that call and returns its result.
+func (g *flightGroup) do(key string, fn func() (*secretSnapshot, error)) (*secretSnapshot, error) {
+	g.mu.Lock()
+	if call, exists := g.calls[key]; exists {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.snapshot, call.err
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.snapshot, call.err = fn()
+	return call.snapshot, call.err
+}
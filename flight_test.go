@@ -0,0 +1,179 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+func TestFlightGroup(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var calls int32
+	fn := func() (*secretSnapshot, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return newSecretSnapshot(map[string]interface{}{"password": "foo"}, nil), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*secretSnapshot, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			snapshot, err := g.do(flightFetch, fn)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results[i] = snapshot
+		}(i)
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("unexpected number of calls: want 1, got %d", n)
+	}
+	for _, snapshot := range results {
+		if snapshot != results[0] {
+			t.Fatalf("callers got different results")
+		}
+	}
+
+	// The key is released once the call completes.
+	if _, err := g.do(flightFetch, fn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("unexpected number of calls: want 2, got %d", n)
+	}
+}
+
+func TestConcurrentCacheMiss(t *testing.T) {
+	testcases := []struct {
+		name string
+		age  time.Duration
+	}{
+		{
+			name: "test concurrent reads of empty cache",
+		},
+		{
+			name: "test concurrent reads of expired secret",
+			age:  90 * time.Second,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			p := &Plugin{
+				ConfigRaw: json.RawMessage(`{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000}`),
+			}
+			if err := p.Provision(ctx); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "bar"}, delay: 50 * time.Millisecond}
+			p.client.SetMockClient(mock)
+			p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+			if tc.age > 0 {
+				p.cache.v.Store(&secretSnapshot{
+					secret:    map[string]interface{}{"password": "foo"},
+					fetchedAt: time.Now().Add(-tc.age),
+				})
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := p.GetSecretByKey(context.TODO(), "password")
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+						return
+					}
+					if got != "bar" {
+						t.Errorf("unexpected value: %v", got)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if n := mock.count(); n != 1 {
+				t.Errorf("unexpected number of requests: want 1, got %d", n)
+			}
+		})
+	}
+}
+
+func TestConcurrentReadsDuringRefresh(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	p := &Plugin{
+		ConfigRaw: json.RawMessage(`{"id":"foo","path":"foo/bar","region":"us-east-1","refresh_interval":5000000,"cache_ttl":10000000}`),
+	}
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("unexpected provisioning error: %v", err)
+	}
+	defer p.Cleanup()
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}}
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := p.GetSecret(context.TODO()); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for _, v := range []string{"bar", "baz", "qux"} {
+		mock.set(map[string]interface{}{"password": v}, false)
+		waitForValue(t, p, "password", v)
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+const (
+	formatJSON   = "json"
+	formatText   = "text"
+	formatBinary = "binary"
+
+	encodingRaw    = "raw"
+	encodingBase64 = "base64"
+	encodingHex    = "hex"
+
+	defaultValueKey = "value"
+)
+
+// validateFormat validates the format related settings of the configuration.
+func validateFormat(cfg *Config) error {
+	switch cfg.Format {
+	case "", formatJSON:
+		if cfg.ValueKey != "" {
+			return fmt.Errorf("secret %q has value key, but its format is not text or binary", cfg.ID)
+		}
+		if cfg.Encoding != "" {
+			return fmt.Errorf("secret %q has encoding, but its format is not binary", cfg.ID)
+		}
+	case formatText:
+		if cfg.Encoding != "" {
+			return fmt.Errorf("secret %q has encoding, but its format is not binary", cfg.ID)
+		}
+	case formatBinary:
+		switch cfg.Encoding {
+		case "", encodingRaw, encodingBase64, encodingHex:
+		default:
+			return fmt.Errorf("secret %q has unsupported %q encoding", cfg.ID, cfg.Encoding)
+		}
+	default:
+		return fmt.Errorf("secret %q has unsupported %q format", cfg.ID, cfg.Format)
+	}
+	return nil
+}
+
+// decodeSecret converts the value of a secret to a key-value map based on
+// the format of the secret.
+func decodeSecret(cfg *Config, result *secretsmanager.GetSecretValueOutput) (map[string]interface{}, error) {
+	switch cfg.Format {
+	case formatBinary:
+		if result.SecretBinary == nil {
+			return nil, errors.New("SecretBinary not found in response")
+		}
+		return map[string]interface{}{
+			getValueKey(cfg): encodeBinary(cfg.Encoding, result.SecretBinary),
+		}, nil
+	}
+
+	if result.SecretString == nil {
+		return nil, errors.New("SecretString not found in response")
+	}
+	if cfg.Format == formatText {
+		return map[string]interface{}{
+			getValueKey(cfg): *result.SecretString,
+		}, nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(*result.SecretString), &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// encodeBinary returns a view of the binary value of a secret.
+func encodeBinary(encoding string, b []byte) interface{} {
+	switch encoding {
+	case encodingBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	case encodingHex:
+		return hex.EncodeToString(b)
+	}
+	return b
+}
+
+// getValueKey returns the key under which non-JSON secrets are exposed.
+func getValueKey(cfg *Config) string {
+	if cfg.ValueKey != "" {
+		return cfg.ValueKey
+	}
+	return defaultValueKey
+}
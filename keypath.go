@@ -0,0 +1,200 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"strconv"
+	"strings"
+)
+
+// lookupKey returns a value of key in the secret key-value map. The key is
+// either a top-level key, a JSON Pointer (RFC 6901), e.g. "/db/primary/password",
+// or a dotted path, e.g. "db.primary.password". In dotted paths, a literal dot
+// is escaped with a backslash, e.g. "db\.primary.password". Array elements are
+// referenced by their index in both syntaxes.
+func lookupKey(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, exists := m[key]; exists {
+		return v, true
+	}
+
+	segments, ok := keySegments(key)
+	if !ok {
+		return nil, false
+	}
+
+	var v interface{} = m
+	for _, segment := range segments {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			item, exists := node[segment]
+			if !exists {
+				return nil, false
+			}
+			v = item
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) || segment != strconv.Itoa(i) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// projectKey copies the value of key in the secret key-value map into the
+// projection. A nested value keeps its path within the nested objects, while
+// the other values of these objects are left out. It reports whether the key
+// was found.
+func projectKey(projection, m map[string]interface{}, key string) bool {
+	if v, exists := m[key]; exists {
+		projection[key] = v
+		return true
+	}
+	if _, exists := lookupKey(projection, key); exists {
+		// The value is part of an object already projected as a whole.
+		return true
+	}
+	segments, ok := keySegments(key)
+	if !ok {
+		return false
+	}
+	src, dst := m, projection
+	for i, segment := range segments {
+		v, exists := src[segment]
+		if !exists {
+			return false
+		}
+		if i == len(segments)-1 {
+			dst[segment] = v
+			return true
+		}
+		next, isObject := v.(map[string]interface{})
+		if !isObject {
+			return false
+		}
+		child, isObject := dst[segment].(map[string]interface{})
+		if !isObject {
+			child = make(map[string]interface{})
+			dst[segment] = child
+		}
+		src, dst = next, child
+	}
+	return false
+}
+
+// deleteKey removes the value of key from the secret key-value map. The
+// nested objects on the path of the value are copied rather than modified,
+// and an object left empty is removed as well.
+func deleteKey(m map[string]interface{}, key string) {
+	if _, exists := m[key]; exists {
+		delete(m, key)
+		return
+	}
+	segments, ok := keySegments(key)
+	if !ok {
+		return
+	}
+	deletePath(m, segments)
+}
+
+func deletePath(m map[string]interface{}, segments []string) {
+	k := segments[0]
+	if len(segments) == 1 {
+		delete(m, k)
+		return
+	}
+	node, isObject := m[k].(map[string]interface{})
+	if !isObject {
+		return
+	}
+	child := make(map[string]interface{}, len(node))
+	for nk, nv := range node {
+		child[nk] = nv
+	}
+	deletePath(child, segments[1:])
+	if len(child) == 0 {
+		delete(m, k)
+		return
+	}
+	m[k] = child
+}
+
+// keySegments splits a JSON Pointer or a dotted path into segments.
+func keySegments(key string) ([]string, bool) {
+	if strings.HasPrefix(key, "/") {
+		return parseJSONPointer(key)
+	}
+	return parseDottedPath(key)
+}
+
+// parseJSONPointer splits a JSON Pointer into unescaped reference tokens.
+func parseJSONPointer(s string) ([]string, bool) {
+	var segments []string
+	for _, token := range strings.Split(s[1:], "/") {
+		var sb strings.Builder
+		for i := 0; i < len(token); i++ {
+			if token[i] != '~' {
+				sb.WriteByte(token[i])
+				continue
+			}
+			if i+1 >= len(token) {
+				return nil, false
+			}
+			i++
+			switch token[i] {
+			case '0':
+				sb.WriteByte('~')
+			case '1':
+				sb.WriteByte('/')
+			default:
+				return nil, false
+			}
+		}
+		segments = append(segments, sb.String())
+	}
+	return segments, true
+}
+
+// parseDottedPath splits a dotted path into unescaped segments.
+func parseDottedPath(s string) ([]string, bool) {
+	var segments []string
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 >= len(s) {
+				return nil, false
+			}
+			i++
+			sb.WriteByte(s[i])
+		case '.':
+			if sb.Len() == 0 {
+				return nil, false
+			}
+			segments = append(segments, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	if sb.Len() == 0 {
+		return nil, false
+	}
+	segments = append(segments, sb.String())
+	return segments, true
+}
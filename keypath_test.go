@@ -0,0 +1,73 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLookupKey(t *testing.T) {
+	secret := map[string]interface{}{
+		"username": "jsmith",
+		"db": map[string]interface{}{
+			"primary": map[string]interface{}{
+				"password": "foo",
+			},
+			"replicas": []interface{}{
+				map[string]interface{}{"password": "bar"},
+				map[string]interface{}{"password": "baz"},
+			},
+		},
+		"db.primary": map[string]interface{}{
+			"password": "qux",
+		},
+		"a/b~c": "slash and tilde",
+	}
+
+	testcases := []struct {
+		name   string
+		key    string
+		want   interface{}
+		exists bool
+	}{
+		{name: "test top-level key", key: "username", want: "jsmith", exists: true},
+		{name: "test dotted path", key: "db.primary.password", want: "foo", exists: true},
+		{name: "test dotted path with array index", key: "db.replicas.1.password", want: "baz", exists: true},
+		{name: "test dotted path with escaped dot", key: `db\.primary.password`, want: "qux", exists: true},
+		{name: "test json pointer", key: "/db/primary/password", want: "foo", exists: true},
+		{name: "test json pointer with array index", key: "/db/replicas/0/password", want: "bar", exists: true},
+		{name: "test json pointer with dot in key", key: "/db.primary/password", want: "qux", exists: true},
+		{name: "test json pointer with escapes", key: "/a~1b~0c", want: "slash and tilde", exists: true},
+		{name: "test dotted path not found", key: "db.secondary.password"},
+		{name: "test array index out of range", key: "db.replicas.2.password"},
+		{name: "test array index with leading zero", key: "db.replicas.01.password"},
+		{name: "test path through scalar", key: "username.foo"},
+		{name: "test dotted path with empty segment", key: "db..primary"},
+		{name: "test json pointer with invalid escape", key: "/a~2b"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, exists := lookupKey(secret, tc.key)
+			if exists != tc.exists {
+				t.Fatalf("lookupKey() exists mismatch: want %t, got %t", tc.exists, exists)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("lookupKey() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
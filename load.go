@@ -0,0 +1,66 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	loadEager = "eager"
+	loadLazy  = "lazy"
+)
+
+// validateLoad validates the load mode of the configuration.
+func validateLoad(cfg *Config) error {
+	switch cfg.Load {
+	case "", loadEager, loadLazy:
+	default:
+		return fmt.Errorf("secret %q has unsupported %q load mode", cfg.ID, cfg.Load)
+	}
+	return nil
+}
+
+// initSecret fetches the secret for the first time, caches it, and starts
+// the background refresh. In eager load mode, it runs when the plugin is
+// validated. In lazy load mode, it runs on the first read of the secret. The
+// secret cached by the previous configuration is used instead of fetching
+// it, as long as it is known to be current. When AWS Secrets Manager is
+// unreachable, failing or throttling, the cache file is used, if any. Other
+// errors, e.g. a missing secret, a denied access or a secret failing the
+// validation, are returned.
+func (p *Plugin) initSecret(ctx context.Context) (*secretSnapshot, error) {
+	snapshot, err := p.loadSharedSecret(ctx)
+	if err != nil && p.Config.CacheFile != "" && isRegionalFailure(err) {
+		snapshot, err = p.fallbackToCacheFile(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Config.PathPrefix != "" && len(snapshot.secret) == 0 {
+		p.logger.Warn(
+			"found no secrets under path prefix",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.String("path_prefix", p.Config.PathPrefix),
+		)
+	}
+	p.storeSecret(snapshot)
+	p.startRefresh()
+	return snapshot, nil
+}
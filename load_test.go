@@ -0,0 +1,120 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+func TestLazyLoad(t *testing.T) {
+	testcases := []struct {
+		name         string
+		cfg          string
+		secret       map[string]interface{}
+		failing      bool
+		want         map[string]interface{}
+		wantRequests []int
+		shouldErr    bool
+		err          error
+	}{
+		{
+			name:         "test lazy load",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","load":"lazy"}`,
+			secret:       map[string]interface{}{"password": "foo"},
+			want:         map[string]interface{}{"password": "foo"},
+			wantRequests: []int{0, 1, 1},
+		},
+		{
+			name:         "test lazy load with failed fetch",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","load":"lazy"}`,
+			failing:      true,
+			wantRequests: []int{0, 1, 2},
+			shouldErr:    true,
+			err: fmt.Errorf("operation error Secrets Manager: GetSecretValue, https response error StatusCode: 400, RequestID: , " +
+				"ResourceNotFoundException: Secrets Manager can't find the specified secret."),
+		},
+		{
+			name:         "test lazy load with missing required key",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","load":"lazy","required":["username"]}`,
+			secret:       map[string]interface{}{"password": "foo"},
+			wantRequests: []int{0, 1, 2},
+			shouldErr:    true,
+			err:          fmt.Errorf("secret %q has missing required keys: %s", "foo", "username"),
+		},
+		{
+			name:         "test eager load",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","load":"eager"}`,
+			secret:       map[string]interface{}{"password": "foo"},
+			want:         map[string]interface{}{"password": "foo"},
+			wantRequests: []int{1, 1, 1},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			p := &Plugin{
+				ConfigRaw: json.RawMessage(tc.cfg),
+			}
+			if err := p.Provision(ctx); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			mock := &mockRotatingSecret{t: t, secret: tc.secret, failing: tc.failing}
+			p.client.SetMockClient(mock)
+			p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+			if err := p.Validate(); err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("unexpected validation error: %v", err)
+				}
+				return
+			}
+			gotRequests := []int{mock.count()}
+
+			for i := 0; i < 2; i++ {
+				got, err := p.GetSecret(context.TODO())
+				gotRequests = append(gotRequests, mock.count())
+				if err != nil {
+					if !tc.shouldErr {
+						t.Fatalf("expected success, got: %v", err)
+					}
+					if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+						t.Fatalf("GetSecret() error mismatch (-want +got):\n%s", diff)
+					}
+					continue
+				}
+				if tc.shouldErr {
+					t.Fatalf("unexpected success, want: %v", tc.err)
+				}
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+				}
+			}
+
+			if diff := cmp.Diff(tc.wantRequests, gotRequests); diff != "" {
+				t.Errorf("unexpected number of requests (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
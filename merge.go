@@ -0,0 +1,104 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	mergePolicyError     = "error"
+	mergePolicyFirstWins = "first_wins"
+	mergePolicyLastWins  = "last_wins"
+)
+
+// pathSecret is a secret fetched from one of the paths of the configuration.
+type pathSecret struct {
+	path   string
+	secret map[string]interface{}
+}
+
+// getPaths returns the paths of the secrets making up the configured secret,
+// in the order of their precedence.
+func (cfg *Config) getPaths() []string {
+	if len(cfg.Paths) > 0 {
+		return cfg.Paths
+	}
+	return []string{cfg.Path}
+}
+
+// validateMerge validates the path and merge settings of the configuration.
+func validateMerge(cfg *Config) error {
+	if cfg.Path == "" && len(cfg.Paths) == 0 && cfg.PathPrefix == "" {
+		return fmt.Errorf("secret %q has empty path", cfg.ID)
+	}
+	if cfg.Path != "" && len(cfg.Paths) > 0 {
+		return fmt.Errorf("secret %q has both path and paths", cfg.ID)
+	}
+	seen := make(map[string]bool)
+	for _, path := range cfg.Paths {
+		if path == "" {
+			return fmt.Errorf("secret %q has empty path", cfg.ID)
+		}
+		if seen[path] {
+			return fmt.Errorf("secret %q has duplicate %q path", cfg.ID, path)
+		}
+		seen[path] = true
+	}
+	if len(cfg.Paths) > 1 && cfg.VersionID != "" {
+		return fmt.Errorf("secret %q has version id, but it has multiple paths", cfg.ID)
+	}
+	switch cfg.MergePolicy {
+	case "", mergePolicyError, mergePolicyFirstWins, mergePolicyLastWins:
+	default:
+		return fmt.Errorf("secret %q has unsupported %q merge policy", cfg.ID, cfg.MergePolicy)
+	}
+	return nil
+}
+
+// mergeSecrets merges the secrets fetched from multiple paths into one.
+// The conflicts between the top-level keys are resolved according to the
+// merge policy of the configuration.
+func mergeSecrets(cfg *Config, secrets []*pathSecret) (map[string]interface{}, error) {
+	if len(secrets) == 1 {
+		return secrets[0].secret, nil
+	}
+
+	m := make(map[string]interface{})
+	sources := make(map[string]string)
+	for _, ps := range secrets {
+		keys := make([]string, 0, len(ps.secret))
+		for k := range ps.secret {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if source, exists := sources[k]; exists {
+				switch cfg.MergePolicy {
+				case mergePolicyFirstWins:
+					continue
+				case mergePolicyLastWins:
+				default:
+					return nil, fmt.Errorf("key %q of %q secret is found in both %q and %q paths", k, cfg.ID, source, ps.path)
+				}
+			}
+			m[k] = ps.secret[k]
+			sources[k] = ps.path
+		}
+	}
+	return m, nil
+}
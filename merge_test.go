@@ -0,0 +1,115 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+func TestMergeSecrets(t *testing.T) {
+	store := map[string]map[string]interface{}{
+		"users/jsmith/profile": {
+			"name":  "John Smith",
+			"email": "jsmith@localhost.localdomain",
+		},
+		"users/jsmith/credentials": {
+			"password": "bcrypt:10:$2a$10$iqq53VjdCwknBSBrnyLd9OH1Mfh6kqPezMMy6h6F41iLdVDkj13I6",
+			"email":    "john.smith@localhost.localdomain",
+		},
+		"users/jsmith/api": {
+			"api_key": "bcrypt:10:$2a$10$TEQ7ZG9cAdWwhQK36orCGOlokqQA55ddE0WEsl00oLZh567okdcZ6",
+		},
+	}
+
+	testcases := []struct {
+		name      string
+		cfg       string
+		want      map[string]interface{}
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test merge without conflicts",
+			cfg:  `{"id":"users/jsmith","paths":["users/jsmith/credentials","users/jsmith/api"],"region":"us-east-1"}`,
+			want: map[string]interface{}{
+				"password": store["users/jsmith/credentials"]["password"],
+				"email":    "john.smith@localhost.localdomain",
+				"api_key":  store["users/jsmith/api"]["api_key"],
+			},
+		},
+		{
+			name:      "test merge with conflicts and default policy",
+			cfg:       `{"id":"users/jsmith","paths":["users/jsmith/profile","users/jsmith/credentials"],"region":"us-east-1"}`,
+			shouldErr: true,
+			err: fmt.Errorf("key %q of %q secret is found in both %q and %q paths",
+				"email", "users/jsmith", "users/jsmith/profile", "users/jsmith/credentials"),
+		},
+		{
+			name: "test merge with conflicts and first wins policy",
+			cfg:  `{"id":"users/jsmith","paths":["users/jsmith/profile","users/jsmith/credentials"],"region":"us-east-1","merge_policy":"first_wins"}`,
+			want: map[string]interface{}{
+				"name":     "John Smith",
+				"email":    "jsmith@localhost.localdomain",
+				"password": store["users/jsmith/credentials"]["password"],
+			},
+		},
+		{
+			name: "test merge with conflicts and last wins policy",
+			cfg:  `{"id":"users/jsmith","paths":["users/jsmith/profile","users/jsmith/credentials"],"region":"us-east-1","merge_policy":"last_wins"}`,
+			want: map[string]interface{}{
+				"name":     "John Smith",
+				"email":    "john.smith@localhost.localdomain",
+				"password": store["users/jsmith/credentials"]["password"],
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Plugin{
+				ConfigRaw: json.RawMessage(tc.cfg),
+			}
+			if err := p.Provision(caddy.ActiveContext()); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			p.client.SetMockClient(newMockSecretStore(t, store))
+			p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+			got, err := p.GetSecret(context.TODO())
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("GetSecret() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
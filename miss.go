@@ -0,0 +1,131 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	missPolicyError       = "error"
+	missPolicyRefetchOnce = "refetch_once"
+	missPolicyRefetch     = "refetch"
+)
+
+// validateMissPolicy validates the missing key settings of the
+// configuration.
+func validateMissPolicy(cfg *Config) error {
+	switch cfg.MissPolicy {
+	case "", missPolicyRefetchOnce, missPolicyRefetch:
+	case missPolicyError:
+		if cfg.NegativeCacheTTL > 0 {
+			return fmt.Errorf("secret %q has negative cache ttl, but its miss policy is %q", cfg.ID, cfg.MissPolicy)
+		}
+	default:
+		return fmt.Errorf("secret %q has unsupported %q miss policy", cfg.ID, cfg.MissPolicy)
+	}
+	if cfg.NegativeCacheTTL < 0 {
+		return fmt.Errorf("secret %q has malformed negative cache ttl", cfg.ID)
+	}
+	return nil
+}
+
+// missCache remembers the keys not found in the secret even after it was
+// refetched. An entry is bound to the versions of the secret cached at the
+// time of the miss, so that the entries are dropped once the secret rotates,
+// but not when the same versions are merely refreshed.
+type missCache struct {
+	mu      sync.Mutex
+	entries map[string]*missEntry
+}
+
+type missEntry struct {
+	versions  map[string]string
+	expiresAt time.Time
+}
+
+func newMissCache() *missCache {
+	return &missCache{
+		entries: make(map[string]*missEntry),
+	}
+}
+
+// contains reports whether the key is remembered as missing from the
+// versions of the snapshot.
+func (c *missCache) contains(key string, snapshot *secretSnapshot) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if !entry.valid(snapshot, time.Now()) {
+		delete(c.entries, key)
+		return false
+	}
+	return true
+}
+
+// add remembers the key as missing from the versions of the snapshot. A zero
+// ttl keeps the entry for as long as the same versions are cached.
+func (c *missCache) add(key string, snapshot *secretSnapshot, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, entry := range c.entries {
+		if !entry.valid(snapshot, now) {
+			delete(c.entries, k)
+		}
+	}
+	entry := &missEntry{}
+	if snapshot != nil {
+		entry.versions = snapshot.versions
+	}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+	c.entries[key] = entry
+}
+
+func (e *missEntry) valid(snapshot *secretSnapshot, now time.Time) bool {
+	if snapshot == nil || !reflect.DeepEqual(e.versions, snapshot.versions) {
+		return false
+	}
+	return e.expiresAt.IsZero() || now.Before(e.expiresAt)
+}
+
+// shouldRefetch reports whether a key not found in the cached secret is
+// looked up in a refetched secret.
+func (p *Plugin) shouldRefetch(key string) bool {
+	if p.Config.MissPolicy == missPolicyError {
+		return false
+	}
+	return !p.misses.contains(key, p.cache.load())
+}
+
+// recordMiss remembers a key not found in a refetched secret. With the
+// refetch_once policy, the key is not refetched again until the negative
+// cache ttl passes or the cached secret rotates. With the refetch policy,
+// the key is remembered only for the negative cache ttl.
+func (p *Plugin) recordMiss(key string) {
+	ttl := time.Duration(p.Config.NegativeCacheTTL)
+	if p.Config.MissPolicy != missPolicyRefetchOnce && ttl <= 0 {
+		return
+	}
+	p.misses.add(key, p.cache.load(), ttl)
+}
@@ -0,0 +1,197 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+func TestMissPolicy(t *testing.T) {
+	testcases := []struct {
+		name         string
+		cfg          string
+		wait         time.Duration
+		rotate       bool
+		refresh      bool
+		wantRequests []int
+	}{
+		{
+			name:         "test default miss policy",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1"}`,
+			wantRequests: []int{2, 3, 4},
+		},
+		{
+			name:         "test refetch miss policy with negative cache ttl",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"refetch","negative_cache_ttl":60000000000}`,
+			wantRequests: []int{2, 2, 2},
+		},
+		{
+			name:         "test refetch miss policy with expired negative cache entry",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"refetch","negative_cache_ttl":10000000}`,
+			wait:         20 * time.Millisecond,
+			wantRequests: []int{2, 3, 4},
+		},
+		{
+			name:         "test refetch once miss policy",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"refetch_once"}`,
+			wantRequests: []int{2, 2, 2},
+		},
+		{
+			name:         "test refetch once miss policy with rotated secret",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"refetch_once"}`,
+			rotate:       true,
+			wantRequests: []int{2, 3, 4},
+		},
+		{
+			name:         "test refetch once miss policy with refreshed secret",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"refetch_once"}`,
+			refresh:      true,
+			wantRequests: []int{2, 2, 2},
+		},
+		{
+			name:         "test error miss policy",
+			cfg:          `{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"error"}`,
+			wantRequests: []int{1, 1, 1},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			p := &Plugin{
+				ConfigRaw: json.RawMessage(tc.cfg),
+			}
+			if err := p.Provision(ctx); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}, versionID: "v1"}
+			p.client.SetMockClient(mock)
+			p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+			if err := p.Validate(); err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+
+			var gotRequests []int
+			for range tc.wantRequests {
+				_, err := p.GetSecretByKey(context.TODO(), "passwrod")
+				want := fmt.Errorf("key %q not found in %q secret", "passwrod", "foo")
+				if err == nil || err.Error() != want.Error() {
+					t.Fatalf("GetSecretByKey() error mismatch: want %v, got %v", want, err)
+				}
+				gotRequests = append(gotRequests, mock.count())
+				current := p.cache.load()
+				switch {
+				case tc.rotate:
+					p.cache.store(newSecretSnapshot(current.secret, map[string]string{"foo/bar": "v2"}))
+				case tc.refresh:
+					p.cache.store(newSecretSnapshot(current.secret, current.versions))
+				}
+				time.Sleep(tc.wait)
+			}
+			if diff := cmp.Diff(tc.wantRequests, gotRequests); diff != "" {
+				t.Errorf("unexpected number of requests (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMissPolicyRefetchedKey(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	p := &Plugin{
+		ConfigRaw: json.RawMessage(`{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"refetch_once"}`),
+	}
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("unexpected provisioning error: %v", err)
+	}
+	defer p.Cleanup()
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}}
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	mock.set(map[string]interface{}{"password": "foo", "username": "jsmith"}, false)
+	for i := 0; i < 3; i++ {
+		got, err := p.GetSecretByKey(context.TODO(), "username")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != "jsmith" {
+			t.Fatalf("unexpected value: %v", got)
+		}
+		// The refetched secret is cached, so the key is not refetched again.
+		if n := mock.count(); n != 2 {
+			t.Fatalf("unexpected number of requests: want 2, got %d", n)
+		}
+	}
+	secret, err := p.GetSecret(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"password": "foo", "username": "jsmith"}, secret); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMissPolicyInvalidRefetch(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	p := &Plugin{
+		ConfigRaw: json.RawMessage(`{"id":"foo","path":"foo/bar","region":"us-east-1","miss_policy":"refetch_once","required":["password"]}`),
+	}
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("unexpected provisioning error: %v", err)
+	}
+	defer p.Cleanup()
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}}
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	// The refetched secret lacks the required key, so it is neither served
+	// nor cached, and it is not refetched again.
+	mock.set(map[string]interface{}{"username": "jsmith"}, false)
+	for i := 0; i < 3; i++ {
+		if _, err := p.GetSecretByKey(context.TODO(), "username"); err == nil {
+			t.Fatalf("unexpected success")
+		}
+		if n := mock.count(); n != 2 {
+			t.Fatalf("unexpected number of requests: want 2, got %d", n)
+		}
+	}
+	secret, err := p.GetSecret(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"password": "foo"}, secret); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+}
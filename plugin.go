@@ -18,10 +18,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
-	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
 	"go.uber.org/zap"
 )
 
@@ -32,6 +32,7 @@ var (
 	// Interface guards
 	_ caddy.Provisioner     = (*Plugin)(nil)
 	_ caddy.Validator       = (*Plugin)(nil)
+	_ caddy.CleanerUpper    = (*Plugin)(nil)
 	_ caddyfile.Unmarshaler = (*Plugin)(nil)
 	_ caddy.Module          = (*Plugin)(nil)
 )
@@ -42,9 +43,58 @@ func init() {
 
 // Config represents provisioned configuration value of AWS Secrets Manager.
 type Config struct {
-	ID     string `json:"id,omitempty" xml:"id,omitempty" yaml:"id,omitempty"`
-	Region string `json:"region,omitempty" xml:"region,omitempty" yaml:"region,omitempty"`
-	Path   string `json:"path,omitempty" xml:"path,omitempty" yaml:"path,omitempty"`
+	ID             string   `json:"id,omitempty" xml:"id,omitempty" yaml:"id,omitempty"`
+	Region         string   `json:"region,omitempty" xml:"region,omitempty" yaml:"region,omitempty"`
+	Regions        []string `json:"regions,omitempty" xml:"regions,omitempty" yaml:"regions,omitempty"`
+	FailoverRegion string   `json:"failover_region,omitempty" xml:"failover_region,omitempty" yaml:"failover_region,omitempty"`
+	Endpoint       string   `json:"endpoint,omitempty" xml:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	UseFIPS        bool     `json:"use_fips,omitempty" xml:"use_fips,omitempty" yaml:"use_fips,omitempty"`
+	UseDualStack   bool     `json:"use_dualstack,omitempty" xml:"use_dualstack,omitempty" yaml:"use_dualstack,omitempty"`
+
+	Profile               string `json:"profile,omitempty" xml:"profile,omitempty" yaml:"profile,omitempty"`
+	SharedConfigFile      string `json:"shared_config_file,omitempty" xml:"shared_config_file,omitempty" yaml:"shared_config_file,omitempty"`
+	SharedCredentialsFile string `json:"shared_credentials_file,omitempty" xml:"shared_credentials_file,omitempty" yaml:"shared_credentials_file,omitempty"`
+
+	RoleARN         string         `json:"role_arn,omitempty" xml:"role_arn,omitempty" yaml:"role_arn,omitempty"`
+	ExternalID      string         `json:"external_id,omitempty" xml:"external_id,omitempty" yaml:"external_id,omitempty"`
+	SessionName     string         `json:"session_name,omitempty" xml:"session_name,omitempty" yaml:"session_name,omitempty"`
+	SessionDuration caddy.Duration `json:"session_duration,omitempty" xml:"session_duration,omitempty" yaml:"session_duration,omitempty"`
+	SourceProfile   string         `json:"source_profile,omitempty" xml:"source_profile,omitempty" yaml:"source_profile,omitempty"`
+
+	WebIdentityTokenFile string `json:"web_identity_token_file,omitempty" xml:"web_identity_token_file,omitempty" yaml:"web_identity_token_file,omitempty"`
+	STSEndpoint          string `json:"sts_endpoint,omitempty" xml:"sts_endpoint,omitempty" yaml:"sts_endpoint,omitempty"`
+
+	Path            string            `json:"path,omitempty" xml:"path,omitempty" yaml:"path,omitempty"`
+	ExpectedAccount string            `json:"expected_account,omitempty" xml:"expected_account,omitempty" yaml:"expected_account,omitempty"`
+	Paths           []string          `json:"paths,omitempty" xml:"paths,omitempty" yaml:"paths,omitempty"`
+	MergePolicy     string            `json:"merge_policy,omitempty" xml:"merge_policy,omitempty" yaml:"merge_policy,omitempty"`
+	PathPrefix      string            `json:"path_prefix,omitempty" xml:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty" xml:"tags,omitempty" yaml:"tags,omitempty"`
+	VersionStage    string            `json:"version_stage,omitempty" xml:"version_stage,omitempty" yaml:"version_stage,omitempty"`
+	VersionID       string            `json:"version_id,omitempty" xml:"version_id,omitempty" yaml:"version_id,omitempty"`
+	Format          string            `json:"format,omitempty" xml:"format,omitempty" yaml:"format,omitempty"`
+	ValueKey        string            `json:"value_key,omitempty" xml:"value_key,omitempty" yaml:"value_key,omitempty"`
+	Encoding        string            `json:"encoding,omitempty" xml:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Aliases         map[string]string `json:"aliases,omitempty" xml:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Only            []string          `json:"only,omitempty" xml:"only,omitempty" yaml:"only,omitempty"`
+	Required        []string          `json:"required,omitempty" xml:"required,omitempty" yaml:"required,omitempty"`
+	Schema          json.RawMessage   `json:"schema,omitempty" xml:"schema,omitempty" yaml:"schema,omitempty"`
+	SchemaFile      string            `json:"schema_file,omitempty" xml:"schema_file,omitempty" yaml:"schema_file,omitempty"`
+	Derived         map[string]string `json:"derived,omitempty" xml:"derived,omitempty" yaml:"derived,omitempty"`
+
+	RefreshInterval      caddy.Duration `json:"refresh_interval,omitempty" xml:"refresh_interval,omitempty" yaml:"refresh_interval,omitempty"`
+	CacheTTL             caddy.Duration `json:"cache_ttl,omitempty" xml:"cache_ttl,omitempty" yaml:"cache_ttl,omitempty"`
+	MaxStale             caddy.Duration `json:"max_stale,omitempty" xml:"max_stale,omitempty" yaml:"max_stale,omitempty"`
+	StaleOnError         bool           `json:"stale_on_error,omitempty" xml:"stale_on_error,omitempty" yaml:"stale_on_error,omitempty"`
+	StaleWhileRevalidate bool           `json:"stale_while_revalidate,omitempty" xml:"stale_while_revalidate,omitempty" yaml:"stale_while_revalidate,omitempty"`
+	TrackRotation        bool           `json:"track_rotation,omitempty" xml:"track_rotation,omitempty" yaml:"track_rotation,omitempty"`
+	MissPolicy           string         `json:"miss_policy,omitempty" xml:"miss_policy,omitempty" yaml:"miss_policy,omitempty"`
+	NegativeCacheTTL     caddy.Duration `json:"negative_cache_ttl,omitempty" xml:"negative_cache_ttl,omitempty" yaml:"negative_cache_ttl,omitempty"`
+	CacheFile            string         `json:"cache_file,omitempty" xml:"cache_file,omitempty" yaml:"cache_file,omitempty"`
+	CacheKeyEnv          string         `json:"cache_key_env,omitempty" xml:"cache_key_env,omitempty" yaml:"cache_key_env,omitempty"`
+	CacheKeyFile         string         `json:"cache_key_file,omitempty" xml:"cache_key_file,omitempty" yaml:"cache_key_file,omitempty"`
+	CacheFileMaxAge      caddy.Duration `json:"cache_file_max_age,omitempty" xml:"cache_file_max_age,omitempty" yaml:"cache_file_max_age,omitempty"`
+	Load                 string         `json:"load,omitempty" xml:"load,omitempty" yaml:"load,omitempty"`
 }
 
 // Plugin manages AWS Secret Manager integration.
@@ -52,9 +102,23 @@ type Plugin struct {
 	Name      string          `json:"-"`
 	ConfigRaw json.RawMessage `json:"config,omitempty" caddy:"namespace=security.secrets.aws_secrets_manager"`
 	Config    Config          `json:"-"`
-	client    aws_secrets_manager.Client
-	secret    map[string]interface{}
+	client    *client
+	clients   []*client
+	cache     secretCache
+	schema    *jsonSchema
+	binding   string
+	derived   []*derivedKey
+	flights   *flightGroup
+	misses    *missCache
+	cacheKey  []byte
+	persisted secretCache
+	refs      *poolRefs
 	logger    *zap.Logger
+
+	ctx          context.Context
+	generation   uint64
+	refreshing   uint32
+	revalidating uint32
 }
 
 // CaddyModule returns the Caddy module information.
@@ -69,6 +133,11 @@ func (Plugin) CaddyModule() caddy.ModuleInfo {
 func (p *Plugin) Provision(ctx caddy.Context) error {
 	p.Name = pluginName
 	p.logger = ctx.Logger(p)
+	p.ctx = ctx
+	p.flights = newFlightGroup()
+	p.misses = newMissCache()
+	p.refs = newPoolRefs()
+	p.generation = configGeneration(ctx.Context)
 
 	p.logger.Info(
 		"provisioning plugin instance",
@@ -93,17 +162,55 @@ func (p *Plugin) Provision(ctx caddy.Context) error {
 		return err
 	}
 
-	client, err := aws_secrets_manager.NewClient(ctx, p.Config.ID, p.Config.Region)
+	schema, err := loadSchema(&p.Config)
+	if err != nil {
+		p.logger.Error(
+			"failed loading secret schema",
+			zap.String("plugin_name", p.Name),
+			zap.Error(err),
+		)
+		return err
+	}
+	p.schema = schema
+	p.binding = secretBinding(&p.Config, schema)
+
+	cacheKey, err := loadCacheKey(&p.Config)
+	if err != nil {
+		p.logger.Error(
+			"failed loading cache key",
+			zap.String("plugin_name", p.Name),
+			zap.Error(err),
+		)
+		return err
+	}
+	p.cacheKey = cacheKey
+
+	derived, err := compileDerivedKeys(&p.Config)
 	if err != nil {
 		p.logger.Error(
-			"failed initializing secrets manager client",
+			"failed compiling derived keys",
 			zap.String("plugin_name", p.Name),
 			zap.Error(err),
 		)
 		return err
 	}
+	p.derived = derived
 
-	p.client = client
+	for _, region := range p.Config.getRegions() {
+		client, err := p.acquireClient(ctx, region)
+		if err != nil {
+			p.logger.Error(
+				"failed initializing secrets manager client",
+				zap.String("plugin_name", p.Name),
+				zap.String("region", region),
+				zap.Error(err),
+			)
+			return err
+		}
+		p.clients = append(p.clients, client)
+	}
+
+	p.client = p.clients[0]
 
 	p.logger.Info(
 		"provisioned plugin instance",
@@ -120,8 +227,16 @@ func (p *Plugin) Validate() error {
 		zap.String("secret_id", p.Config.ID),
 	)
 
-	secret, err := p.GetSecret(context.TODO())
-	if err != nil {
+	if p.Config.Load == loadLazy {
+		p.logger.Info(
+			"skipped fetching secret in lazy load mode",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+		)
+		return nil
+	}
+
+	if _, err := p.initSecret(context.TODO()); err != nil {
 		p.logger.Error(
 			"failed validating plugin instance",
 			zap.String("plugin_name", p.Name),
@@ -130,7 +245,6 @@ func (p *Plugin) Validate() error {
 		)
 		return err
 	}
-	p.secret = secret
 
 	p.logger.Info(
 		"validated plugin instance",
@@ -140,23 +254,133 @@ func (p *Plugin) Validate() error {
 	return nil
 }
 
+// Cleanup implements caddy.CleanerUpper. It releases the AWS SDK client and
+// the fetched secrets shared with the other plugin instances.
+func (p *Plugin) Cleanup() error {
+	if p.refs == nil {
+		return nil
+	}
+	return p.refs.release()
+}
+
 // ValidateConfig validates configuration.
 func (p *Plugin) ValidateConfig() error {
 	if p.Config.ID == "" {
 		return fmt.Errorf("empty id")
 	}
-	if p.Config.Path == "" {
-		return fmt.Errorf("secret %q has empty path", p.Config.ID)
+	if err := validateDiscovery(&p.Config); err != nil {
+		return err
+	}
+	if err := validateMerge(&p.Config); err != nil {
+		return err
+	}
+	if err := validateARNs(&p.Config); err != nil {
+		return err
 	}
-	if p.Config.Region == "" {
+	if p.Config.Region == "" && len(p.Config.Regions) == 0 && p.Config.arnRegion() == "" {
 		return fmt.Errorf("secret %q has empty region", p.Config.ID)
 	}
+	if err := validateRegions(&p.Config); err != nil {
+		return err
+	}
+	if err := validateFormat(&p.Config); err != nil {
+		return err
+	}
+	if err := validateTransform(&p.Config); err != nil {
+		return err
+	}
+	if err := validateRequiredKeys(&p.Config); err != nil {
+		return err
+	}
+	if err := validateSchemaConfig(&p.Config); err != nil {
+		return err
+	}
+	if _, err := compileDerivedKeys(&p.Config); err != nil {
+		return err
+	}
+	if err := validateRefresh(&p.Config); err != nil {
+		return err
+	}
+	if err := validateCachePolicy(&p.Config); err != nil {
+		return err
+	}
+	if err := validateRotation(&p.Config); err != nil {
+		return err
+	}
+	if err := validateMissPolicy(&p.Config); err != nil {
+		return err
+	}
+	if err := validateCacheFile(&p.Config); err != nil {
+		return err
+	}
+	if err := validateLoad(&p.Config); err != nil {
+		return err
+	}
+	if err := validateEndpoint(&p.Config); err != nil {
+		return err
+	}
+	if err := validateAssumeRole(&p.Config); err != nil {
+		return err
+	}
 	return nil
 }
 
 // GetConfig returns plugin configuration.
 func (p *Plugin) GetConfig(ctx context.Context) map[string]interface{} {
 	m := p.client.GetConfig(ctx)
-	m["path"] = p.Config.Path
+	if regions := p.Config.getRegions(); len(regions) > 1 {
+		m["regions"] = regions
+	}
+	switch {
+	case p.Config.PathPrefix != "":
+		m["path_prefix"] = p.Config.PathPrefix
+	case len(p.Config.Paths) > 0:
+		m["paths"] = p.Config.Paths
+	default:
+		m["path"] = p.Config.Path
+	}
+	if p.Config.VersionStage != "" {
+		m["version_stage"] = p.Config.VersionStage
+	}
+	if p.Config.VersionID != "" {
+		m["version_id"] = p.Config.VersionID
+	}
+	if p.Config.Format != "" {
+		m["format"] = p.Config.Format
+	}
 	return m
 }
+
+// secretRequest returns the version of the secret at the path the plugin is
+// configured for.
+func (p *Plugin) secretRequest(path string) *secretRequest {
+	return &secretRequest{
+		Path:         path,
+		VersionID:    p.Config.VersionID,
+		VersionStage: p.Config.VersionStage,
+	}
+}
+
+// getClientConfig returns the settings of the client of AWS Secrets Manager.
+func (cfg *Config) getClientConfig() *clientConfig {
+	return &clientConfig{
+		ID:           cfg.ID,
+		Region:       cfg.getRegions()[0],
+		Endpoint:     cfg.Endpoint,
+		UseFIPS:      cfg.UseFIPS,
+		UseDualStack: cfg.UseDualStack,
+
+		Profile:               cfg.Profile,
+		SharedConfigFile:      cfg.SharedConfigFile,
+		SharedCredentialsFile: cfg.SharedCredentialsFile,
+
+		RoleARN:         cfg.RoleARN,
+		ExternalID:      cfg.ExternalID,
+		SessionName:     cfg.SessionName,
+		SessionDuration: time.Duration(cfg.SessionDuration),
+		SourceProfile:   cfg.SourceProfile,
+
+		WebIdentityTokenFile: cfg.WebIdentityTokenFile,
+		STSEndpoint:          cfg.STSEndpoint,
+	}
+}
@@ -46,6 +46,59 @@ func TestProvisionPlugin(t *testing.T) {
 				Region: "us-east-1",
 			},
 		},
+		{
+			name: "test provisioning config with version stage and id",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","version_stage":"AWSPENDING","version_id":"EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE"}`,
+			want: Config{
+				ID:           "foo",
+				Path:         "foo/bar",
+				Region:       "us-east-1",
+				VersionStage: "AWSPENDING",
+				VersionID:    "EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE",
+			},
+		},
+		{
+			name:      "test provisioning config with unsupported format",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","format":"yaml"}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has unsupported %q format", "foo", "yaml"),
+		},
+		{
+			name:      "test provisioning config with encoding of json secret",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","encoding":"hex"}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has encoding, but its format is not binary", "foo"),
+		},
+		{
+			name:      "test provisioning config with value key of json secret",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","value_key":"hmac"}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has value key, but its format is not text or binary", "foo"),
+		},
+		{
+			name:      "test provisioning config with encoding of text secret",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","format":"text","encoding":"hex"}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has encoding, but its format is not binary", "foo"),
+		},
+		{
+			name:      "test provisioning config with inline schema and schema file",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","schema":{"type":"object"},"schema_file":"schema.json"}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has both inline schema and schema file", "foo"),
+		},
+		{
+			name:      "test provisioning config with version id and multiple paths",
+			cfg:       `{"id":"foo","paths":["foo/bar","foo/baz"],"region":"us-east-1","version_id":"EXAMPLE1-90ab-cdef-fedc-ba987EXAMPLE"}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has version id, but it has multiple paths", "foo"),
+		},
+		{
+			name:      "test provisioning config with path and paths",
+			cfg:       `{"id":"foo","path":"foo/bar","paths":["foo/baz"],"region":"us-east-1"}`,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has both path and paths", "foo"),
+		},
 		{
 			name:      "test provisioning malformed json config",
 			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1"`,
@@ -78,6 +131,7 @@ func TestProvisionPlugin(t *testing.T) {
 				ConfigRaw: json.RawMessage(tc.cfg),
 			}
 			err := p.Provision(caddy.ActiveContext())
+			defer p.Cleanup()
 			if err != nil {
 				if !tc.shouldErr {
 					t.Fatalf("expected success, got: %v", err)
@@ -128,6 +182,42 @@ func TestValidatePlugin(t *testing.T) {
 			},
 			secret: jsmith,
 		},
+		{
+			name: "test validating config with version stage",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","version_stage":"AWSPREVIOUS"}`,
+			want: map[string]interface{}{
+				"id":            "foo",
+				"path":          "foo/bar",
+				"region":        "us-east-1",
+				"provider":      "aws_secrets_manager",
+				"version_stage": "AWSPREVIOUS",
+			},
+			secret: jsmith,
+		},
+		{
+			name: "test validating config with present required keys",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","required":["username","password"]}`,
+			want: map[string]interface{}{
+				"id":       "foo",
+				"path":     "foo/bar",
+				"region":   "us-east-1",
+				"provider": "aws_secrets_manager",
+			},
+			secret: jsmith,
+		},
+		{
+			name:      "test validating config with missing required keys",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","required":["username","roles","token"]}`,
+			secret:    jsmith,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has missing required keys: %s", "foo", "roles, token"),
+		}, {
+			name:      "test validating config with schema violations",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","schema":{"properties":{"password":{"pattern":"^bcrypt:12:"}}}}`,
+			secret:    jsmith,
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q failed schema validation: %s", "foo", `/password: must match "^bcrypt:12:" pattern`),
+		},
 	}
 
 	for _, tc := range testcases {
@@ -139,6 +229,7 @@ func TestValidatePlugin(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected provisioning error: %v", err)
 			}
+			defer p.Cleanup()
 
 			var mockClient aws.HTTPClient = smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
 				response := packMapToJSON(t, map[string]interface{}{
@@ -0,0 +1,430 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// The fetched secrets are shared by the plugin instances of the current and
+// the previous configurations. When the configuration is reloaded, the new
+// instances start with the secrets cached by the previous ones having the
+// same settings, as long as the secrets are known to be current. The AWS SDK
+// clients are shared by the instances of the same configuration only, so
+// that the credentials are loaded again on reload. The entries are released
+// once no instance uses them.
+var (
+	clientPool   = caddy.NewUsagePool()
+	secretPool   = caddy.NewUsagePool()
+	snapshotPool = caddy.NewUsagePool()
+)
+
+// configGenerations numbers the loaded Caddy configurations, each one
+// identified by the context its modules are provisioned with. The number of
+// a configuration is forgotten once the configuration is unloaded.
+var configGenerations = struct {
+	sync.Mutex
+	ids  map[context.Context]uint64
+	last uint64
+}{
+	ids: make(map[context.Context]uint64),
+}
+
+// configGeneration returns the number of the configuration provisioned with
+// the context.
+func configGeneration(ctx context.Context) uint64 {
+	if ctx == nil {
+		return 0
+	}
+	configGenerations.Lock()
+	defer configGenerations.Unlock()
+	if id, exists := configGenerations.ids[ctx]; exists {
+		return id
+	}
+	configGenerations.last++
+	id := configGenerations.last
+	configGenerations.ids[ctx] = id
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			configGenerations.Lock()
+			defer configGenerations.Unlock()
+			delete(configGenerations.ids, ctx)
+		}()
+	}
+	return id
+}
+
+// sharedFetchWindow is the age below which a secret fetched by one plugin
+// instance is used by the other instances instead of fetching it again.
+const sharedFetchWindow = time.Second
+
+// pooledSecret is the last value of a version of a secret fetched from AWS
+// Secrets Manager. The plugin instances fetching the same version share the
+// value, and their concurrent fetches are collapsed into a single call. It is
+// safe for concurrent use. The raw value, including the keys the instances
+// drop, is kept only within the shared fetch window.
+type pooledSecret struct {
+	mu        sync.Mutex
+	output    *secretsmanager.GetSecretValueOutput
+	fetchedAt time.Time
+	inflight  *pooledFetch
+	expiry    *time.Timer
+}
+
+// pooledFetch is a fetch of a pooled secret in progress.
+type pooledFetch struct {
+	done      chan struct{}
+	output    *secretsmanager.GetSecretValueOutput
+	fetchedAt time.Time
+	err       error
+}
+
+// get returns the value fetched at or after notBefore. Otherwise, it calls
+// fetch, unless another fetch is in progress, in which case it waits for
+// that fetch and returns its result. The last return value is true when the
+// value was not fetched by this call.
+func (s *pooledSecret) get(ctx context.Context, notBefore time.Time, fetch func() (*secretsmanager.GetSecretValueOutput, error)) (*secretsmanager.GetSecretValueOutput, time.Time, bool, error) {
+	s.mu.Lock()
+	if s.output != nil && !s.fetchedAt.Before(notBefore) {
+		output, fetchedAt := s.output, s.fetchedAt
+		s.mu.Unlock()
+		return output, fetchedAt, true, nil
+	}
+	if f := s.inflight; f != nil {
+		s.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.output, f.fetchedAt, true, f.err
+		case <-ctx.Done():
+			return nil, time.Time{}, true, ctx.Err()
+		}
+	}
+	f := &pooledFetch{done: make(chan struct{})}
+	s.inflight = f
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.inflight = nil
+		if f.err == nil && !f.fetchedAt.Before(s.fetchedAt) {
+			s.output = f.output
+			s.fetchedAt = f.fetchedAt
+			if s.expiry != nil {
+				s.expiry.Stop()
+			}
+			s.expiry = time.AfterFunc(sharedFetchWindow, func() {
+				s.drop(f.fetchedAt)
+			})
+		}
+		s.mu.Unlock()
+		close(f.done)
+	}()
+	f.output, f.err = fetch()
+	f.fetchedAt = time.Now()
+	return f.output, f.fetchedAt, false, f.err
+}
+
+// drop drops the value fetched at the time, unless it was replaced.
+func (s *pooledSecret) drop(fetchedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fetchedAt.Equal(fetchedAt) {
+		s.output = nil
+	}
+}
+
+// Destruct implements caddy.Destructor.
+func (s *pooledSecret) Destruct() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	s.output = nil
+	return nil
+}
+
+// pooledSnapshot is the last secret cached by the plugin instances having
+// the same settings. It is safe for concurrent use.
+type pooledSnapshot struct {
+	mu       sync.Mutex
+	snapshot *secretSnapshot
+}
+
+func (s *pooledSnapshot) load() *secretSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot
+}
+
+// store replaces the snapshot, unless the pooled one was fetched later.
+func (s *pooledSnapshot) store(snapshot *secretSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshot != nil && s.snapshot.fetchedAt.After(snapshot.fetchedAt) {
+		return
+	}
+	s.snapshot = snapshot
+}
+
+// Destruct implements caddy.Destructor.
+func (s *pooledSnapshot) Destruct() error {
+	return nil
+}
+
+type poolRef struct {
+	pool *caddy.UsagePool
+	key  string
+}
+
+// poolRefs tracks the pool entries used by a plugin instance. Each entry is
+// referenced once per instance, and it is released when the instance is
+// cleaned up. It also tracks the fetch time of the last value the instance
+// got from each entry.
+type poolRefs struct {
+	mu       sync.Mutex
+	values   map[poolRef]interface{}
+	seen     map[poolRef]time.Time
+	released bool
+}
+
+func newPoolRefs() *poolRefs {
+	return &poolRefs{
+		values: make(map[poolRef]interface{}),
+		seen:   make(map[poolRef]time.Time),
+	}
+}
+
+// lastSeen returns the fetch time of the last value got from the entry.
+func (r *poolRefs) lastSeen(pool *caddy.UsagePool, key string) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seen[poolRef{pool: pool, key: key}]
+}
+
+// see records the fetch time of a value got from the entry.
+func (r *poolRefs) see(pool *caddy.UsagePool, key string, fetchedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ref := poolRef{pool: pool, key: key}
+	if fetchedAt.After(r.seen[ref]) {
+		r.seen[ref] = fetchedAt
+	}
+}
+
+// loadOrNew returns the value of the pool entry, constructing it when the
+// pool has none. Once the entries are released, e.g. when a background
+// refresh outlives the instance, the value is constructed outside of the
+// pool, so that no entry is left behind.
+func (r *poolRefs) loadOrNew(pool *caddy.UsagePool, key string, construct caddy.Constructor) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.released {
+		return construct()
+	}
+	ref := poolRef{pool: pool, key: key}
+	if v, exists := r.values[ref]; exists {
+		return v, nil
+	}
+	v, _, err := pool.LoadOrNew(key, construct)
+	if err != nil {
+		return nil, err
+	}
+	r.values[ref] = v
+	return v, nil
+}
+
+// release releases all of the referenced pool entries.
+func (r *poolRefs) release() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.released = true
+	var firstErr error
+	for ref := range r.values {
+		if _, err := ref.pool.Delete(ref.key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(r.values, ref)
+		delete(r.seen, ref)
+	}
+	return firstErr
+}
+
+// clientPoolKey returns the key of the AWS SDK client of the region in the
+// pool. The key includes the generation of the configuration, so that the
+// client is not shared across reloads.
+func (p *Plugin) clientPoolKey(cfg *clientConfig) string {
+	return cfg.poolKey() + "|" + strconv.FormatUint(p.generation, 10)
+}
+
+// secretPoolKey returns the key of a version of a secret in the pool.
+func secretPoolKey(cfg *Config, req *secretRequest) string {
+	stage := req.VersionStage
+	if stage == "" && req.VersionID == "" {
+		stage = defaultVersionStage
+	}
+	return strings.Join([]string{cfg.getClientConfig().poolKey(), req.Path, req.VersionID, stage}, "|")
+}
+
+// snapshotPoolKey returns the key of the cached secret in the pool. The
+// instances share the cached secret only when they have the same settings
+// selecting, shaping and validating it.
+func (p *Plugin) snapshotPoolKey() string {
+	return strings.Join([]string{p.Config.getClientConfig().poolKey(), p.binding}, "|")
+}
+
+// acquireClient returns a client of the region using the AWS SDK client of
+// the pool.
+func (p *Plugin) acquireClient(ctx context.Context, region string) (*client, error) {
+	cfg := p.Config.getClientConfig()
+	cfg.Region = region
+	v, err := p.refs.loadOrNew(clientPool, p.clientPoolKey(cfg), func() (caddy.Destructor, error) {
+		return newClientService(ctx, cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newClientWithService(cfg, v.(*clientService)), nil
+}
+
+// getSecretValue returns the value of the secret at the path along with the
+// time it was fetched. The value is fetched, unless another plugin instance
+// fetched a newer value than this instance has within the shared fetch
+// window, or it is fetching the value right now.
+func (p *Plugin) getSecretValue(ctx context.Context, path string) (*secretsmanager.GetSecretValueOutput, time.Time, error) {
+	req := p.secretRequest(path)
+	key := secretPoolKey(&p.Config, req)
+	v, err := p.refs.loadOrNew(secretPool, key, func() (caddy.Destructor, error) {
+		return &pooledSecret{}, nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	notBefore := time.Now().Add(-sharedFetchWindow)
+	if seen := p.refs.lastSeen(secretPool, key); !seen.Before(notBefore) {
+		notBefore = seen.Add(time.Nanosecond)
+	}
+	output, fetchedAt, shared, err := v.(*pooledSecret).get(ctx, notBefore, func() (*secretsmanager.GetSecretValueOutput, error) {
+		var output *secretsmanager.GetSecretValueOutput
+		region, err := p.withFailover(ctx, func(c *client) error {
+			var err error
+			regionalReq := *req
+			regionalReq.Path = regionalPath(req.Path, c.config.Region)
+			output, err = c.GetSecretValue(ctx, &regionalReq)
+			return err
+		})
+		if err == nil && len(p.clients) > 1 {
+			p.logger.Info(
+				"fetched secret from region",
+				zap.String("plugin_name", p.Name),
+				zap.String("secret_id", p.Config.ID),
+				zap.String("path", path),
+				zap.String("region", region),
+			)
+		}
+		return output, err
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := p.checkAccount(path, output); err != nil {
+		return nil, time.Time{}, err
+	}
+	p.refs.see(secretPool, key, fetchedAt)
+	if shared {
+		p.logger.Debug(
+			"reusing fetched secret",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.String("path", path),
+			zap.Duration("age", time.Since(fetchedAt)),
+		)
+	}
+	return output, fetchedAt, nil
+}
+
+// pooledSnapshot returns the entry of the pool holding the secret cached by
+// the instances having the same settings.
+func (p *Plugin) pooledSnapshot() (*pooledSnapshot, error) {
+	v, err := p.refs.loadOrNew(snapshotPool, p.snapshotPoolKey(), func() (caddy.Destructor, error) {
+		return &pooledSnapshot{}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*pooledSnapshot), nil
+}
+
+// shareSecret makes the cached snapshot available to the instances of the
+// next configuration.
+func (p *Plugin) shareSecret(snapshot *secretSnapshot) {
+	pooled, err := p.pooledSnapshot()
+	if err != nil {
+		return
+	}
+	pooled.store(snapshot)
+}
+
+// reuseWindow returns the age up to which the secret cached by the instance
+// of the previous configuration is used as is. It is the age up to which the
+// previous instance served the secret without fetching it again, i.e. the
+// cache ttl or the refresh interval, whichever is shorter. Without either,
+// the secret is always fetched again.
+func reuseWindow(cfg *Config) time.Duration {
+	ttl, interval := time.Duration(cfg.CacheTTL), time.Duration(cfg.RefreshInterval)
+	if ttl > 0 && (interval <= 0 || ttl < interval) {
+		return ttl
+	}
+	return interval
+}
+
+// loadSharedSecret returns the secret cached by the instance of the previous
+// configuration having the same settings, provided that it is known to be
+// current. The secret is current when it is within the reuse window or, with
+// rotation tracking, when its versions are unchanged. Otherwise, the secret
+// is loaded from AWS Secrets Manager.
+func (p *Plugin) loadSharedSecret(ctx context.Context) (*secretSnapshot, error) {
+	pooled, err := p.pooledSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := pooled.load()
+	if snapshot == nil {
+		return p.loadSecret(ctx)
+	}
+	age := time.Since(snapshot.fetchedAt)
+	if window := reuseWindow(&p.Config); window > 0 && age <= window {
+		p.logger.Debug(
+			"reusing cached secret",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.Duration("age", age),
+		)
+		return snapshot, nil
+	}
+	if p.Config.TrackRotation {
+		return p.reloadSecret(ctx, snapshot)
+	}
+	return p.loadSecret(ctx)
+}
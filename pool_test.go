@@ -0,0 +1,400 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+func newProvisionedPlugin(t *testing.T, cfg string) *Plugin {
+	p := &Plugin{
+		ConfigRaw: json.RawMessage(cfg),
+	}
+	if err := p.Provision(caddy.ActiveContext()); err != nil {
+		t.Fatalf("unexpected provisioning error: %v", err)
+	}
+	return p
+}
+
+// ageSharedSecret moves the fetch times of the secrets shared by the plugin
+// instance back by d, as if the secrets were fetched d earlier.
+func ageSharedSecret(t *testing.T, p *Plugin, d time.Duration) {
+	p.refs.mu.Lock()
+	for ref, v := range p.refs.values {
+		if pooled, ok := v.(*pooledSecret); ok && ref.pool == secretPool {
+			pooled.mu.Lock()
+			pooled.fetchedAt = pooled.fetchedAt.Add(-d)
+			pooled.mu.Unlock()
+		}
+	}
+	p.refs.mu.Unlock()
+	pooled, err := p.pooledSnapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pooled.mu.Lock()
+	defer pooled.mu.Unlock()
+	aged := *pooled.snapshot
+	aged.fetchedAt = aged.fetchedAt.Add(-d)
+	pooled.snapshot = &aged
+}
+
+func TestConfigReload(t *testing.T) {
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}}
+
+	// The instance of the previous configuration.
+	prev := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":"1h"}`)
+	defer prev.Cleanup()
+	prev.client.SetMockClient(mock)
+	prev.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if err := prev.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	// The instance of the reloaded configuration having the same settings
+	// reuses the client and the secret within its cache ttl.
+	next := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":"1h"}`)
+	defer next.Cleanup()
+	if next.client.service != prev.client.service {
+		t.Fatalf("client is not shared between configurations")
+	}
+	if err := next.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if n := mock.count(); n != 1 {
+		t.Fatalf("unexpected number of requests: want 1, got %d", n)
+	}
+	if !next.cache.load().fetchedAt.Equal(prev.cache.load().fetchedAt) {
+		t.Errorf("reused secret has different fetch time")
+	}
+
+	// The instance without cache ttl picks up the rotated secret.
+	mock.set(map[string]interface{}{"password": "bar"}, false)
+	ageSharedSecret(t, prev, 2*sharedFetchWindow)
+	uncached := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1","aliases":{"pass":"password"}}`)
+	defer uncached.Cleanup()
+	if err := uncached.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if n := mock.count(); n != 2 {
+		t.Fatalf("unexpected number of requests: want 2, got %d", n)
+	}
+	got, err := uncached.GetSecret(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"pass": "bar"}, got); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+
+	// The instance having the same settings fetches the secret cached for
+	// longer than its cache ttl.
+	ageSharedSecret(t, uncached, 2*sharedFetchWindow)
+	ageSharedSecret(t, next, 2*time.Hour)
+	expired := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":"1h"}`)
+	defer expired.Cleanup()
+	if err := expired.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if n := mock.count(); n != 3 {
+		t.Fatalf("unexpected number of requests: want 3, got %d", n)
+	}
+	got, err = expired.GetSecret(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"password": "bar"}, got); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+
+	// The instance of another region does not share the client.
+	other := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-west-2"}`)
+	defer other.Cleanup()
+	if other.client.service == prev.client.service {
+		t.Fatalf("client is shared between regions")
+	}
+
+	// The entries are released once unused.
+	for _, p := range []*Plugin{uncached, expired, prev} {
+		if err := p.Cleanup(); err != nil {
+			t.Fatalf("unexpected cleanup error: %v", err)
+		}
+	}
+	key := next.snapshotPoolKey()
+	if refs, exists := snapshotPool.References(key); !exists || refs != 1 {
+		t.Fatalf("unexpected references to cached secret after cleanup of previous instances: %d", refs)
+	}
+	if err := next.Cleanup(); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+	if _, exists := snapshotPool.References(key); exists {
+		t.Fatalf("cached secret is not released after cleanup")
+	}
+	if _, exists := secretPool.References(secretPoolKey(&next.Config, next.secretRequest("foo/bar"))); exists {
+		t.Fatalf("secret is not released after cleanup")
+	}
+	if _, exists := clientPool.References(next.clientPoolKey(next.client.config)); exists {
+		t.Fatalf("client is not released after cleanup")
+	}
+}
+
+func TestConfigReloadCredentials(t *testing.T) {
+	for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+		t.Setenv(k, "")
+	}
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	writeCredentials := func(accessKey string) {
+		content := "[default]\naws_access_key_id = " + accessKey + "\naws_secret_access_key = secret\n"
+		if err := ioutil.WriteFile(credentialsFile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	provision := func(ctx caddy.Context) *Plugin {
+		p := &Plugin{
+			ConfigRaw: json.RawMessage(`{"id":"foo","path":"foo/bar","region":"us-east-1","shared_credentials_file":"` + credentialsFile + `"}`),
+		}
+		if err := p.Provision(ctx); err != nil {
+			t.Fatalf("unexpected provisioning error: %v", err)
+		}
+		return p
+	}
+	accessKey := func(p *Plugin) string {
+		creds, err := p.client.service.config.Credentials.Retrieve(context.TODO())
+		if err != nil {
+			t.Fatalf("unexpected error retrieving credentials: %v", err)
+		}
+		return creds.AccessKeyID
+	}
+
+	writeCredentials("AKIAPREVIOUS")
+	prevCtx, prevCancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer prevCancel()
+	prev := provision(prevCtx)
+	defer prev.Cleanup()
+	sibling := provision(prevCtx)
+	defer sibling.Cleanup()
+	if sibling.client.service != prev.client.service {
+		t.Fatalf("client is not shared within configuration")
+	}
+
+	// The reloaded configuration loads the rotated credentials.
+	writeCredentials("AKIANEXT")
+	nextCtx, nextCancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer nextCancel()
+	next := provision(nextCtx)
+	defer next.Cleanup()
+	if next.client.service == prev.client.service {
+		t.Fatalf("client is shared across configurations")
+	}
+	if got := accessKey(next); got != "AKIANEXT" {
+		t.Errorf("unexpected access key of reloaded configuration: want AKIANEXT, got %s", got)
+	}
+	if got := accessKey(prev); got != "AKIAPREVIOUS" {
+		t.Errorf("unexpected access key of previous configuration: want AKIAPREVIOUS, got %s", got)
+	}
+}
+
+func TestConfigReloadRotation(t *testing.T) {
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}, versionID: "v1"}
+	cfg := `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":"1m","track_rotation":true}`
+
+	prev := newProvisionedPlugin(t, cfg)
+	defer prev.Cleanup()
+	prev.client.SetMockClient(mock)
+	prev.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if err := prev.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	// Past its cache ttl, the cached secret is reused once its version is
+	// confirmed.
+	ageSharedSecret(t, prev, 2*time.Minute)
+	next := newProvisionedPlugin(t, cfg)
+	defer next.Cleanup()
+	if err := next.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if n := mock.countTarget("secretsmanager.GetSecretValue"); n != 1 {
+		t.Fatalf("unexpected number of secret value requests: want 1, got %d", n)
+	}
+	if n := mock.countTarget("secretsmanager.DescribeSecret"); n != 1 {
+		t.Fatalf("unexpected number of describe requests: want 1, got %d", n)
+	}
+
+	// The rotated secret is fetched.
+	mock.mu.Lock()
+	mock.secret = map[string]interface{}{"password": "bar"}
+	mock.versionID = "v2"
+	mock.mu.Unlock()
+	ageSharedSecret(t, next, 2*time.Minute)
+	rotated := newProvisionedPlugin(t, cfg)
+	defer rotated.Cleanup()
+	if err := rotated.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if n := mock.countTarget("secretsmanager.GetSecretValue"); n != 2 {
+		t.Fatalf("unexpected number of secret value requests: want 2, got %d", n)
+	}
+	got, err := rotated.GetSecretByKey(context.TODO(), "password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "bar" {
+		t.Errorf("unexpected password: want bar, got %v", got)
+	}
+}
+
+func TestSharedFetch(t *testing.T) {
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"username": "jsmith", "password": "foo"}, delay: 50 * time.Millisecond}
+
+	users := newProvisionedPlugin(t, `{"id":"username","path":"foo/bar","region":"us-east-1","only":["username"],"load":"lazy"}`)
+	defer users.Cleanup()
+	passwords := newProvisionedPlugin(t, `{"id":"password","path":"foo/bar","region":"us-east-1","only":["password"],"load":"lazy"}`)
+	defer passwords.Cleanup()
+	previous := newProvisionedPlugin(t, `{"id":"previous","path":"foo/bar","region":"us-east-1","version_stage":"AWSPREVIOUS","load":"lazy"}`)
+	defer previous.Cleanup()
+	users.client.SetMockClient(mock)
+	users.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+	// The concurrent fetches of the same version are collapsed.
+	var wg sync.WaitGroup
+	results := make([]map[string]interface{}, 2)
+	for i, p := range []*Plugin{users, passwords} {
+		wg.Add(1)
+		go func(i int, p *Plugin) {
+			defer wg.Done()
+			secret, err := p.GetSecret(context.TODO())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results[i] = secret
+		}(i, p)
+	}
+	wg.Wait()
+	if n := mock.count(); n != 1 {
+		t.Fatalf("unexpected number of requests: want 1, got %d", n)
+	}
+
+	// Each instance keeps its own view.
+	if diff := cmp.Diff(map[string]interface{}{"username": "jsmith"}, results[0]); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"password": "foo"}, results[1]); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Another version is fetched separately.
+	if _, err := previous.GetSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := mock.count(); n != 2 {
+		t.Fatalf("unexpected number of requests: want 2, got %d", n)
+	}
+
+	// A refetch by one instance is shared with the others, but not with
+	// itself.
+	if _, err := users.fetchSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := passwords.fetchSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := mock.count(); n != 3 {
+		t.Fatalf("unexpected number of requests: want 3, got %d", n)
+	}
+	if _, err := users.fetchSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := mock.count(); n != 4 {
+		t.Fatalf("unexpected number of requests: want 4, got %d", n)
+	}
+}
+
+func TestSharedFetchWindow(t *testing.T) {
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"username": "jsmith", "password": "foo"}}
+
+	p := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1","only":["username"],"load":"lazy"}`)
+	defer p.Cleanup()
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if _, err := p.GetSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ref := poolRef{pool: secretPool, key: secretPoolKey(&p.Config, p.secretRequest("foo/bar"))}
+	p.refs.mu.Lock()
+	pooled := p.refs.values[ref].(*pooledSecret)
+	p.refs.mu.Unlock()
+	rawValue := func() *secretsmanager.GetSecretValueOutput {
+		pooled.mu.Lock()
+		defer pooled.mu.Unlock()
+		return pooled.output
+	}
+	if rawValue() == nil {
+		t.Fatalf("raw value is not shared within shared fetch window")
+	}
+
+	// The raw value, including the dropped keys, is not kept past the shared
+	// fetch window, while the cached secret is.
+	deadline := time.Now().Add(sharedFetchWindow + 5*time.Second)
+	for rawValue() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("raw value is kept past shared fetch window")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	got, err := p.GetSecret(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"username": "jsmith"}, got); diff != "" {
+		t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+	}
+	if n := mock.count(); n != 1 {
+		t.Fatalf("unexpected number of requests: want 1, got %d", n)
+	}
+}
+
+func TestFetchAfterCleanup(t *testing.T) {
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"username": "jsmith"}}
+
+	p := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1","load":"lazy"}`)
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+	if err := p.Cleanup(); err != nil {
+		t.Fatalf("unexpected cleanup error: %v", err)
+	}
+
+	// A fetch outliving the instance, e.g. a background refresh, leaves
+	// nothing in the pool.
+	if _, err := p.fetchSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := secretPoolKey(&p.Config, p.secretRequest("foo/bar"))
+	if refs, exists := secretPool.References(key); exists {
+		t.Fatalf("secret is left in the pool with %d references after cleanup", refs)
+	}
+}
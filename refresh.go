@@ -0,0 +1,100 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// refreshJitter is the fraction of the refresh interval by which each
+// refresh is randomly advanced or delayed, so that a fleet of instances
+// does not refresh at the same moment.
+const refreshJitter = 0.1
+
+// validateRefresh validates the refresh settings of the configuration.
+func validateRefresh(cfg *Config) error {
+	if cfg.RefreshInterval < 0 {
+		return fmt.Errorf("secret %q has negative refresh interval", cfg.ID)
+	}
+	return nil
+}
+
+// startRefresh starts refreshing the cached secret in the background. The
+// refresh stops when the context of the plugin is cancelled, i.e. when the
+// configuration is unloaded.
+func (p *Plugin) startRefresh() {
+	if p.Config.RefreshInterval <= 0 {
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&p.refreshing, 0, 1) {
+		return
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	go p.refreshLoop(p.ctx, rnd)
+}
+
+func (p *Plugin) refreshLoop(ctx context.Context, rnd *rand.Rand) {
+	interval := time.Duration(p.Config.RefreshInterval)
+	for {
+		timer := time.NewTimer(jitterInterval(interval, rnd))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		p.refresh(ctx)
+	}
+}
+
+// refresh refetches the secret and replaces the cached one. On failure,
+// the cached secret is kept.
+func (p *Plugin) refresh(ctx context.Context) error {
+	snapshot, err := p.flights.do(flightReload, func() (*secretSnapshot, error) {
+		return p.reloadSecret(ctx, p.cache.load())
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		p.logger.Error(
+			"failed refreshing secret",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.Error(err),
+		)
+		return err
+	}
+	p.storeSecret(snapshot)
+	p.logger.Debug(
+		"refreshed secret",
+		zap.String("plugin_name", p.Name),
+		zap.String("secret_id", p.Config.ID),
+	)
+	return nil
+}
+
+// jitterInterval returns the interval randomly advanced or delayed by up to
+// the refresh jitter.
+func jitterInterval(d time.Duration, rnd *rand.Rand) time.Duration {
+	delta := (rnd.Float64()*2 - 1) * refreshJitter * float64(d)
+	return d + time.Duration(delta)
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+// mockRotatingSecret is a mock AWS Secrets Manager API serving a secret
+// whose value changes over time. It is safe for concurrent use.
+type mockRotatingSecret struct {
+	mu        sync.Mutex
+	t         *testing.T
+	secret    map[string]interface{}
+	versionID string
+	failing   bool
+	delay     time.Duration
+	requests  int
+	targets   map[string]int
+}
+
+func (m *mockRotatingSecret) set(secret map[string]interface{}, failing bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secret = secret
+	m.failing = failing
+}
+
+func (m *mockRotatingSecret) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests
+}
+
+// countTarget returns the number of requests to the API operation, e.g.
+// "secretsmanager.DescribeSecret".
+func (m *mockRotatingSecret) countTarget(target string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.targets[target]
+}
+
+// Do implements aws.HTTPClient.
+func (m *mockRotatingSecret) Do(r *http.Request) (*http.Response, error) {
+	time.Sleep(m.delay)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	target := r.Header.Get("X-Amz-Target")
+	if m.targets == nil {
+		m.targets = make(map[string]int)
+	}
+	m.targets[target]++
+	if m.failing {
+		response := packMapToJSON(m.t, map[string]interface{}{
+			"__type":  "ResourceNotFoundException",
+			"Message": "Secrets Manager can't find the specified secret.",
+		})
+		return &http.Response{
+			StatusCode: 400,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(response)),
+		}, nil
+	}
+	var response string
+	switch target {
+	case "secretsmanager.DescribeSecret":
+		response = packMapToJSON(m.t, map[string]interface{}{
+			"VersionIdsToStages": map[string]interface{}{
+				m.versionID: []string{"AWSCURRENT"},
+			},
+		})
+	default:
+		body := map[string]interface{}{
+			"SecretString": packMapToJSON(m.t, m.secret),
+		}
+		if m.versionID != "" {
+			body["VersionId"] = m.versionID
+		}
+		response = packMapToJSON(m.t, body)
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(response)),
+	}, nil
+}
+
+// waitForValue polls the plugin until the key has the value.
+func waitForValue(t *testing.T, p *Plugin, key string, want interface{}) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		got, err := p.GetSecretByKey(context.TODO(), key)
+		if err == nil && got == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %q key to have %v value", key, want)
+}
+
+func TestJitterInterval(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	interval := time.Minute
+	for i := 0; i < 1000; i++ {
+		got := jitterInterval(interval, rnd)
+		if got < 54*time.Second || got > 66*time.Second {
+			t.Fatalf("jitterInterval() returned %v, out of bounds of %v interval", got, interval)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	p := &Plugin{
+		ConfigRaw: json.RawMessage(`{"id":"foo","path":"foo/bar","region":"us-east-1","refresh_interval":20000000}`),
+	}
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("unexpected provisioning error: %v", err)
+	}
+	defer p.Cleanup()
+
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}}
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	mock.set(map[string]interface{}{"password": "bar"}, false)
+	waitForValue(t, p, "password", "bar")
+
+	// The cached secret is kept when the refresh fails.
+	mock.set(nil, true)
+	n := mock.count()
+	for mock.count() < n+2 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	got, err := p.GetSecretByKey(context.TODO(), "password")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "bar" {
+		t.Fatalf("unexpected value after failed refresh: %v", got)
+	}
+
+	// The refresh stops when the context is cancelled.
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	n = mock.count()
+	time.Sleep(100 * time.Millisecond)
+	if mock.count() != n {
+		t.Fatalf("refresh continued after context cancellation")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.uber.org/zap"
+)
+
+// regionRetryInterval is the time a region is skipped for after a failed
+// call, unless all of the regions are failing.
+const regionRetryInterval = 30 * time.Second
+
+// validateRegions validates the failover settings of the configuration.
+func validateRegions(cfg *Config) error {
+	if cfg.Endpoint != "" && len(cfg.getRegions()) > 1 {
+		return fmt.Errorf("secret %q has endpoint, but it also has multiple regions", cfg.ID)
+	}
+	if len(cfg.Regions) == 0 {
+		if cfg.FailoverRegion == cfg.getRegion() && cfg.FailoverRegion != "" {
+			return fmt.Errorf("secret %q has failover region %q same as its region", cfg.ID, cfg.FailoverRegion)
+		}
+		return nil
+	}
+	if cfg.FailoverRegion != "" {
+		return fmt.Errorf("secret %q has both regions and failover region", cfg.ID)
+	}
+	if cfg.Region != "" && cfg.Region != cfg.Regions[0] {
+		return fmt.Errorf("secret %q has %q region, but its regions start with %q", cfg.ID, cfg.Region, cfg.Regions[0])
+	}
+	seen := make(map[string]bool)
+	for _, region := range cfg.Regions {
+		if !awsRegionRgx.MatchString(region) {
+			return fmt.Errorf("secret %q has malformed %q region", cfg.ID, region)
+		}
+		if seen[region] {
+			return fmt.Errorf("secret %q has duplicate %q region", cfg.ID, region)
+		}
+		seen[region] = true
+	}
+	return nil
+}
+
+// getRegions returns the regions the secret is read from, in order of
+// preference.
+func (cfg *Config) getRegions() []string {
+	switch {
+	case len(cfg.Regions) > 0:
+		return cfg.Regions
+	case cfg.FailoverRegion != "":
+		return []string{cfg.getRegion(), cfg.FailoverRegion}
+	default:
+		return []string{cfg.getRegion()}
+	}
+}
+
+// getRegion returns the configured region, or the region of the ARN paths.
+func (cfg *Config) getRegion() string {
+	if cfg.Region != "" {
+		return cfg.Region
+	}
+	return cfg.arnRegion()
+}
+
+// healthy reports whether the region of the AWS SDK client is not skipped
+// after a failed call.
+func (s *clientService) healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !time.Now().Before(s.unhealthyUntil)
+}
+
+func (s *clientService) markFailed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unhealthyUntil = time.Now().Add(regionRetryInterval)
+}
+
+func (s *clientService) markHealthy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unhealthyUntil = time.Time{}
+}
+
+// orderClients returns the clients to try. The clients of healthy regions
+// come first, in order of preference, followed by the unhealthy ones as the
+// last resort.
+func (p *Plugin) orderClients() []*client {
+	var healthy, unhealthy []*client
+	for _, c := range p.clients {
+		if c.service.healthy() {
+			healthy = append(healthy, c)
+			continue
+		}
+		unhealthy = append(unhealthy, c)
+	}
+	return append(healthy, unhealthy...)
+}
+
+// isRegionalFailure reports whether the error is caused by the region rather
+// than by the request, i.e. the service could not be reached, it failed with
+// a server error, or it throttled the request. Other errors, e.g. a missing
+// secret or a denied access, fail the same way in every region.
+func isRegionalFailure(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		if code := respErr.HTTPStatusCode(); code >= 500 || code == http.StatusTooManyRequests {
+			return true
+		}
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		_, throttled := retry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]
+		return throttled
+	}
+	var sendErr *smithyhttp.RequestSendError
+	return errors.As(err, &sendErr)
+}
+
+// withFailover calls fn with the client of each region until a call
+// succeeds, and returns the region of that call. Only a regional failure
+// moves on to the next region, and the failing region is skipped for the
+// retry interval by every plugin sharing its AWS SDK client. When all of the
+// regions fail, the error of the last one is returned.
+func (p *Plugin) withFailover(ctx context.Context, fn func(*client) error) (string, error) {
+	var err error
+	for _, c := range p.orderClients() {
+		region := c.config.Region
+		if err = fn(c); err == nil {
+			c.service.markHealthy()
+			return region, nil
+		}
+		if ctx.Err() != nil || len(p.clients) == 1 || !isRegionalFailure(err) {
+			return "", err
+		}
+		c.service.markFailed()
+		p.logger.Warn(
+			"failed calling secrets manager in region",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.String("region", region),
+			zap.Error(err),
+		)
+	}
+	return "", err
+}
@@ -0,0 +1,335 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+// mockRegion is a mock HTTP client of AWS Secrets Manager in a region. The
+// region responds with the secret, unless it is failing in the given way.
+type mockRegion struct {
+	t        *testing.T
+	secret   map[string]interface{}
+	failure  string
+	mu       sync.Mutex
+	requests int
+}
+
+func (m *mockRegion) Do(r *http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	status := http.StatusBadRequest
+	var errType string
+	switch m.failure {
+	case "unreachable":
+		return nil, fmt.Errorf("dial tcp: connect: connection refused")
+	case "unavailable":
+		status = http.StatusServiceUnavailable
+		errType = "ServiceUnavailableException"
+	case "throttled":
+		errType = "ThrottlingException"
+	case "not_found":
+		errType = "ResourceNotFoundException"
+	case "access_denied":
+		errType = "AccessDeniedException"
+	default:
+		response := packMapToJSON(m.t, map[string]interface{}{"SecretString": packMapToJSON(m.t, m.secret)})
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(response)),
+		}, nil
+	}
+	response := packMapToJSON(m.t, map[string]interface{}{"__type": errType, "Message": m.failure})
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(response)),
+	}, nil
+}
+
+func (m *mockRegion) setFailure(failure string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failure = failure
+}
+
+func (m *mockRegion) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests
+}
+
+// setMockRegionClient makes the client of a region use the mock HTTP client
+// without retrying the failed requests.
+func setMockRegionClient(c *client, mock aws.HTTPClient) {
+	c.service.config.Retryer = func() aws.Retryer { return aws.NopRetryer{} }
+	c.SetMockClient(mock)
+	c.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+}
+
+func TestRegionFailover(t *testing.T) {
+	testcases := []struct {
+		name     string
+		cfg      string
+		failures []string
+		// want is the number of requests per region after each of the two
+		// fetches.
+		want      [][]int
+		shouldErr bool
+	}{
+		{
+			name:     "test healthy primary region",
+			cfg:      `{"id":"foo","path":"foo/bar","regions":["us-east-1","us-west-2"],"load":"lazy"}`,
+			failures: []string{"", ""},
+			want:     [][]int{{1, 0}, {2, 0}},
+		},
+		{
+			name:     "test failover from unavailable region",
+			cfg:      `{"id":"foo","path":"foo/bar","regions":["us-east-1","us-west-2","eu-west-1"],"load":"lazy"}`,
+			failures: []string{"unavailable", "", ""},
+			want:     [][]int{{1, 1, 0}, {1, 2, 0}},
+		},
+		{
+			name:     "test failover from unreachable region",
+			cfg:      `{"id":"foo","path":"foo/bar","region":"us-east-1","failover_region":"us-west-2","load":"lazy"}`,
+			failures: []string{"unreachable", ""},
+			want:     [][]int{{1, 1}, {1, 2}},
+		},
+		{
+			name:     "test failover from throttling region",
+			cfg:      `{"id":"foo","path":"foo/bar","region":"us-east-1","failover_region":"us-west-2","load":"lazy"}`,
+			failures: []string{"throttled", ""},
+			want:     [][]int{{1, 1}, {1, 2}},
+		},
+		{
+			name:      "test no failover on missing secret",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","failover_region":"us-west-2","load":"lazy"}`,
+			failures:  []string{"not_found", ""},
+			want:      [][]int{{1, 0}, {2, 0}},
+			shouldErr: true,
+		},
+		{
+			name:      "test no failover on denied access",
+			cfg:       `{"id":"foo","path":"foo/bar","region":"us-east-1","failover_region":"us-west-2","load":"lazy"}`,
+			failures:  []string{"access_denied", ""},
+			want:      [][]int{{1, 0}, {2, 0}},
+			shouldErr: true,
+		},
+		{
+			name:      "test all regions failing",
+			cfg:       `{"id":"foo","path":"foo/bar","regions":["us-east-1","us-west-2"],"load":"lazy"}`,
+			failures:  []string{"unavailable", "unreachable"},
+			want:      [][]int{{1, 1}, {2, 2}},
+			shouldErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProvisionedPlugin(t, tc.cfg)
+			defer p.Cleanup()
+
+			var mocks []*mockRegion
+			for i, failure := range tc.failures {
+				mock := &mockRegion{t: t, secret: map[string]interface{}{"username": "jsmith"}, failure: failure}
+				setMockRegionClient(p.clients[i], mock)
+				mocks = append(mocks, mock)
+			}
+
+			for _, want := range tc.want {
+				secret, err := p.fetchSecret(context.TODO())
+				if tc.shouldErr {
+					if err == nil {
+						t.Fatalf("unexpected success")
+					}
+				} else {
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if diff := cmp.Diff(map[string]interface{}{"username": "jsmith"}, secret.secret); diff != "" {
+						t.Errorf("fetchSecret() mismatch (-want +got):\n%s", diff)
+					}
+				}
+				if diff := cmp.Diff(want, countRequests(mocks...)); diff != "" {
+					t.Fatalf("unexpected number of requests per region (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+// countRequests returns the number of requests per region.
+func countRequests(mocks ...*mockRegion) []int {
+	var counts []int
+	for _, mock := range mocks {
+		counts = append(counts, mock.count())
+	}
+	return counts
+}
+
+func TestRegionHealthShared(t *testing.T) {
+	first := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","regions":["us-east-1","us-west-2"],"load":"lazy"}`)
+	defer first.Cleanup()
+	second := newProvisionedPlugin(t, `{"id":"bar","path":"foo/baz","regions":["us-east-1","us-west-2"],"load":"lazy"}`)
+	defer second.Cleanup()
+
+	for i := range first.clients {
+		if first.clients[i].service != second.clients[i].service {
+			t.Fatalf("unexpected separate AWS SDK clients in %q region", first.clients[i].config.Region)
+		}
+	}
+
+	primary := &mockRegion{t: t, secret: map[string]interface{}{"username": "jsmith"}, failure: "unavailable"}
+	replica := &mockRegion{t: t, secret: map[string]interface{}{"username": "jsmith"}}
+	setMockRegionClient(first.clients[0], primary)
+	setMockRegionClient(first.clients[1], replica)
+
+	if _, err := first.fetchSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]int{1, 1}, countRequests(primary, replica)); diff != "" {
+		t.Fatalf("unexpected number of requests per region (-want +got):\n%s", diff)
+	}
+
+	// The other instance skips the region failed by the first one.
+	if _, err := second.fetchSecret(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]int{1, 2}, countRequests(primary, replica)); diff != "" {
+		t.Fatalf("unexpected number of requests per region (-want +got):\n%s", diff)
+	}
+}
+
+func TestRegionRecovery(t *testing.T) {
+	p := newProvisionedPlugin(t, `{"id":"foo","path":"foo/bar","region":"us-east-1","failover_region":"us-west-2","load":"lazy"}`)
+	defer p.Cleanup()
+
+	primary := &mockRegion{t: t, secret: map[string]interface{}{"username": "jsmith"}, failure: "unavailable"}
+	replica := &mockRegion{t: t, secret: map[string]interface{}{"username": "jsmith"}}
+	setMockRegionClient(p.clients[0], primary)
+	setMockRegionClient(p.clients[1], replica)
+
+	steps := []struct {
+		name string
+		// recover makes the primary region respond again.
+		recover bool
+		// elapse moves the time of the retry of the primary region to the
+		// past.
+		elapse bool
+		want   []int
+	}{
+		{name: "primary region fails", want: []int{1, 1}},
+		{name: "primary region is skipped", want: []int{1, 2}},
+		{name: "recovered primary region is skipped until retry", recover: true, want: []int{1, 3}},
+		{name: "primary region is retried after retry interval", elapse: true, want: []int{2, 3}},
+		{name: "primary region is healthy", want: []int{3, 3}},
+	}
+	for _, step := range steps {
+		if step.recover {
+			primary.setFailure("")
+		}
+		if step.elapse {
+			service := p.clients[0].service
+			service.mu.Lock()
+			service.unhealthyUntil = service.unhealthyUntil.Add(-regionRetryInterval)
+			service.mu.Unlock()
+		}
+		if _, err := p.fetchSecret(context.TODO()); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if diff := cmp.Diff(step.want, countRequests(primary, replica)); diff != "" {
+			t.Fatalf("%s: unexpected number of requests per region (-want +got):\n%s", step.name, diff)
+		}
+	}
+	if !p.clients[0].service.healthy() {
+		t.Fatalf("unexpected unhealthy %q region", p.clients[0].config.Region)
+	}
+}
+
+func TestValidateRegions(t *testing.T) {
+	testcases := []struct {
+		name string
+		cfg  *Config
+		err  error
+	}{
+		{
+			name: "test config with single region",
+			cfg:  &Config{ID: "foo", Region: "us-east-1"},
+		},
+		{
+			name: "test config with region matching regions",
+			cfg:  &Config{ID: "foo", Region: "us-east-1", Regions: []string{"us-east-1", "us-west-2"}},
+		},
+		{
+			name: "test config with region not matching regions",
+			cfg:  &Config{ID: "foo", Region: "us-west-2", Regions: []string{"us-east-1", "us-west-2"}},
+			err:  fmt.Errorf("secret %q has %q region, but its regions start with %q", "foo", "us-west-2", "us-east-1"),
+		},
+		{
+			name: "test config with regions and failover region",
+			cfg:  &Config{ID: "foo", Regions: []string{"us-east-1"}, FailoverRegion: "us-west-2"},
+			err:  fmt.Errorf("secret %q has both regions and failover region", "foo"),
+		},
+		{
+			name: "test config with duplicate region",
+			cfg:  &Config{ID: "foo", Regions: []string{"us-east-1", "us-east-1"}},
+			err:  fmt.Errorf("secret %q has duplicate %q region", "foo", "us-east-1"),
+		},
+		{
+			name: "test config with malformed region",
+			cfg:  &Config{ID: "foo", Regions: []string{"us-east-1", "east"}},
+			err:  fmt.Errorf("secret %q has malformed %q region", "foo", "east"),
+		},
+		{
+			name: "test config with endpoint and failover region",
+			cfg:  &Config{ID: "foo", Region: "us-east-1", FailoverRegion: "us-west-2", Endpoint: "http://localhost:4566"},
+			err:  fmt.Errorf("secret %q has endpoint, but it also has multiple regions", "foo"),
+		},
+		{
+			name: "test config with failover region same as region",
+			cfg:  &Config{ID: "foo", Region: "us-east-1", FailoverRegion: "us-east-1"},
+			err:  fmt.Errorf("secret %q has failover region %q same as its region", "foo", "us-east-1"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRegions(tc.cfg)
+			if tc.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.err.Error(), err.Error()); diff != "" {
+				t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+}
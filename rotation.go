@@ -0,0 +1,91 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// validateRotation validates the rotation tracking settings of the
+// configuration.
+func validateRotation(cfg *Config) error {
+	if !cfg.TrackRotation {
+		return nil
+	}
+	if cfg.PathPrefix != "" {
+		return fmt.Errorf("secret %q has rotation tracking, but it has path prefix", cfg.ID)
+	}
+	if cfg.VersionID != "" {
+		return fmt.Errorf("secret %q has rotation tracking, but it has version id", cfg.ID)
+	}
+	if cfg.RefreshInterval == 0 && cfg.CacheTTL == 0 {
+		return fmt.Errorf("secret %q has rotation tracking, but it has neither refresh interval nor cache ttl", cfg.ID)
+	}
+	return nil
+}
+
+// reloadSecret returns a fresh snapshot of the secret. With rotation
+// tracking, the value of the secret is retrieved only when the version of
+// any of its paths has changed since the current snapshot. Otherwise, the
+// current value is kept and only its fetch time is renewed.
+func (p *Plugin) reloadSecret(ctx context.Context, current *secretSnapshot) (*secretSnapshot, error) {
+	if !p.Config.TrackRotation || current == nil {
+		return p.loadSecret(ctx)
+	}
+	rotated, err := p.isRotated(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	if rotated {
+		return p.loadSecret(ctx)
+	}
+	return newSecretSnapshot(current.secret, current.versions), nil
+}
+
+// isRotated reports whether the version of any of the paths of the secret
+// differs from the version in the snapshot.
+func (p *Plugin) isRotated(ctx context.Context, current *secretSnapshot) (bool, error) {
+	stage := p.Config.VersionStage
+	if stage == "" {
+		stage = defaultVersionStage
+	}
+	for _, path := range p.Config.getPaths() {
+		var versionID string
+		_, err := p.withFailover(ctx, func(c *client) error {
+			var err error
+			versionID, err = c.GetVersionID(ctx, regionalPath(path, c.config.Region), stage)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		if versionID == current.versions[path] {
+			continue
+		}
+		p.logger.Info(
+			"detected secret rotation",
+			zap.String("plugin_name", p.Name),
+			zap.String("secret_id", p.Config.ID),
+			zap.String("path", path),
+			zap.String("old_version_id", current.versions[path]),
+			zap.String("new_version_id", versionID),
+		)
+		return true, nil
+	}
+	return false, nil
+}
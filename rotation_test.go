@@ -0,0 +1,172 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/go-cmp/cmp"
+	aws_secrets_manager "github.com/greenpau/go-authcrunch-secrets-aws-secrets-manager"
+)
+
+func TestRotationTracking(t *testing.T) {
+	cached := map[string]interface{}{"password": "foo"}
+	fetched := map[string]interface{}{"password": "bar"}
+
+	testcases := []struct {
+		name          string
+		cfg           string
+		versionID     string
+		failing       bool
+		want          map[string]interface{}
+		wantDescribes int
+		wantGets      int
+		wantVersionID string
+		shouldErr     bool
+		err           error
+	}{
+		{
+			name:          "test expired secret without rotation tracking",
+			cfg:           `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000}`,
+			versionID:     "v1",
+			want:          fetched,
+			wantGets:      1,
+			wantVersionID: "v1",
+		},
+		{
+			name:          "test expired secret with unchanged version",
+			cfg:           `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"track_rotation":true}`,
+			versionID:     "v1",
+			want:          cached,
+			wantDescribes: 1,
+			wantVersionID: "v1",
+		},
+		{
+			name:          "test expired secret with rotated version",
+			cfg:           `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"track_rotation":true}`,
+			versionID:     "v2",
+			want:          fetched,
+			wantDescribes: 1,
+			wantGets:      1,
+			wantVersionID: "v2",
+		},
+		{
+			name:          "test expired secret with failed version check",
+			cfg:           `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"track_rotation":true}`,
+			failing:       true,
+			wantDescribes: 1,
+			shouldErr:     true,
+			err: fmt.Errorf("operation error Secrets Manager: DescribeSecret, https response error StatusCode: 400, RequestID: , " +
+				"ResourceNotFoundException: Secrets Manager can't find the specified secret."),
+		},
+		{
+			name:          "test expired secret with failed version check and stale on error",
+			cfg:           `{"id":"foo","path":"foo/bar","region":"us-east-1","cache_ttl":60000000000,"track_rotation":true,"stale_on_error":true}`,
+			failing:       true,
+			want:          cached,
+			wantDescribes: 1,
+			wantVersionID: "v1",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			p := &Plugin{
+				ConfigRaw: json.RawMessage(tc.cfg),
+			}
+			if err := p.Provision(ctx); err != nil {
+				t.Fatalf("unexpected provisioning error: %v", err)
+			}
+			defer p.Cleanup()
+			mock := &mockRotatingSecret{t: t, secret: fetched, versionID: tc.versionID, failing: tc.failing}
+			p.client.SetMockClient(mock)
+			p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+			p.cache.store(&secretSnapshot{
+				secret:    cached,
+				versions:  map[string]string{"foo/bar": "v1"},
+				fetchedAt: time.Now().Add(-90 * time.Second),
+			})
+
+			got, err := p.GetSecret(context.TODO())
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("GetSecret() error mismatch (-want +got):\n%s", diff)
+				}
+			} else {
+				if tc.shouldErr {
+					t.Fatalf("unexpected success, want: %v", tc.err)
+				}
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Errorf("GetSecret() mismatch (-want +got):\n%s", diff)
+				}
+				if got := p.cache.load().versions["foo/bar"]; got != tc.wantVersionID {
+					t.Errorf("unexpected cached version id: want %q, got %q", tc.wantVersionID, got)
+				}
+			}
+
+			if n := mock.countTarget("secretsmanager.DescribeSecret"); n != tc.wantDescribes {
+				t.Errorf("unexpected number of DescribeSecret requests: want %d, got %d", tc.wantDescribes, n)
+			}
+			if n := mock.countTarget("secretsmanager.GetSecretValue"); n != tc.wantGets {
+				t.Errorf("unexpected number of GetSecretValue requests: want %d, got %d", tc.wantGets, n)
+			}
+		})
+	}
+}
+
+func TestRefreshWithRotationTracking(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	p := &Plugin{
+		ConfigRaw: json.RawMessage(`{"id":"foo","path":"foo/bar","region":"us-east-1","refresh_interval":20000000,"track_rotation":true}`),
+	}
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("unexpected provisioning error: %v", err)
+	}
+	defer p.Cleanup()
+
+	mock := &mockRotatingSecret{t: t, secret: map[string]interface{}{"password": "foo"}, versionID: "v1"}
+	p.client.SetMockClient(mock)
+	p.client.SetMockCredentialsProvider(aws_secrets_manager.MockCredentialsProvider{})
+
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	// The value is not retrieved while the version is unchanged.
+	for mock.countTarget("secretsmanager.DescribeSecret") < 3 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := mock.countTarget("secretsmanager.GetSecretValue"); n != 1 {
+		t.Fatalf("unexpected number of GetSecretValue requests: want 1, got %d", n)
+	}
+
+	mock.mu.Lock()
+	mock.secret = map[string]interface{}{"password": "bar"}
+	mock.versionID = "v2"
+	mock.mu.Unlock()
+	waitForValue(t, p, "password", "bar")
+}
@@ -0,0 +1,318 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema is a subset of JSON Schema used to validate the contents of
+// secrets. It supports the type, enum, const, string length and pattern,
+// numeric range, object properties and array items keywords. The annotation
+// keywords are accepted, but they have no effect. Any other keyword is
+// rejected, so that a schema never silently validates less than it says.
+type jsonSchema struct {
+	SchemaURI   json.RawMessage `json:"$schema,omitempty"`
+	SchemaID    json.RawMessage `json:"$id,omitempty"`
+	Comment     json.RawMessage `json:"$comment,omitempty"`
+	Title       json.RawMessage `json:"title,omitempty"`
+	Description json.RawMessage `json:"description,omitempty"`
+	Default     json.RawMessage `json:"default,omitempty"`
+	Examples    json.RawMessage `json:"examples,omitempty"`
+
+	Type                 schemaType             `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Const                interface{}            `json:"const,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+	// digest is the digest of the schema document.
+	digest string
+}
+
+// schemaType is the value of the type keyword, either a single type or a
+// list of types.
+type schemaType []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (st *schemaType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*st = schemaType{s}
+		return nil
+	}
+	var arr []string
+	if err := json.Unmarshal(b, &arr); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*st = schemaType(arr)
+	return nil
+}
+
+// schemaViolation is a single failed rule of a schema.
+type schemaViolation struct {
+	Path    string
+	Message string
+}
+
+// validateSchemaConfig validates the schema settings of the configuration.
+// The schema file is read during provisioning.
+func validateSchemaConfig(cfg *Config) error {
+	if len(cfg.Schema) > 0 && cfg.SchemaFile != "" {
+		return fmt.Errorf("secret %q has both inline schema and schema file", cfg.ID)
+	}
+	if len(cfg.Schema) > 0 {
+		if _, err := parseSchema(cfg.Schema); err != nil {
+			return fmt.Errorf("secret %q has malformed schema: %v", cfg.ID, err)
+		}
+	}
+	return nil
+}
+
+// loadSchema returns the schema configured either inline or in a file.
+func loadSchema(cfg *Config) (*jsonSchema, error) {
+	var b []byte
+	switch {
+	case len(cfg.Schema) > 0:
+		b = cfg.Schema
+	case cfg.SchemaFile != "":
+		content, err := ioutil.ReadFile(cfg.SchemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("secret %q has unreadable schema file: %v", cfg.ID, err)
+		}
+		b = content
+	default:
+		return nil, nil
+	}
+	schema, err := parseSchema(b)
+	if err != nil {
+		return nil, fmt.Errorf("secret %q has malformed schema: %v", cfg.ID, err)
+	}
+	return schema, nil
+}
+
+// parseSchema parses and compiles a schema.
+func parseSchema(b []byte) (*jsonSchema, error) {
+	schema := &jsonSchema{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(schema); err != nil {
+		if k := strings.TrimPrefix(err.Error(), "json: unknown field "); k != err.Error() {
+			return nil, fmt.Errorf("unsupported %s keyword", k)
+		}
+		return nil, err
+	}
+	if err := schema.compile("/"); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	schema.digest = hex.EncodeToString(sum[:])
+	return schema, nil
+}
+
+func (s *jsonSchema) compile(path string) error {
+	for _, t := range s.Type {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("%s: unsupported %q type", path, t)
+		}
+	}
+	if s.Pattern != "" {
+		rgx, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %v", path, err)
+		}
+		s.pattern = rgx
+	}
+	for k, prop := range s.Properties {
+		if prop == nil {
+			return fmt.Errorf("%s: empty schema of %q property", path, k)
+		}
+		if err := prop.compile(joinSchemaPath(path, k)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(joinSchemaPath(path, "items")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSchema checks the secret against the schema. The error lists the
+// location and the failed rule of each violation, but not the values.
+func validateSchema(cfg *Config, schema *jsonSchema, secret map[string]interface{}) error {
+	if schema == nil {
+		return nil
+	}
+	var violations []schemaViolation
+	schema.validate("", secret, &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	var msgs []string
+	for _, v := range violations {
+		msgs = append(msgs, v.Path+": "+v.Message)
+	}
+	return fmt.Errorf("secret %q failed schema validation: %s", cfg.ID, strings.Join(msgs, "; "))
+}
+
+func (s *jsonSchema) validate(path string, v interface{}, violations *[]schemaViolation) {
+	report := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		*violations = append(*violations, schemaViolation{Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		report("must be of %s type", strings.Join(s.Type, " or "))
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		var found bool
+		for _, item := range s.Enum {
+			if reflect.DeepEqual(item, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("must be one of the enumerated values")
+		}
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(s.Const, v) {
+		report("must be equal to the constant value")
+	}
+
+	switch value := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(value)
+		if s.MinLength != nil && n < *s.MinLength {
+			report("length must be at least %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			report("length must be at most %d", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			report("must match %q pattern", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			report("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && value > *s.Maximum {
+			report("must be at most %v", *s.Maximum)
+		}
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if _, exists := value[k]; !exists {
+				report("missing required %q property", k)
+			}
+		}
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, exists := s.Properties[k]
+			if !exists {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					report("unexpected %q property", k)
+				}
+				continue
+			}
+			prop.validate(joinSchemaPath(path, k), value[k], violations)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			report("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			report("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				s.Items.validate(joinSchemaPath(path, fmt.Sprintf("%d", i)), item, violations)
+			}
+		}
+	}
+}
+
+func (st schemaType) matches(v interface{}) bool {
+	for _, t := range st {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && value == math.Trunc(value)) {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case nil:
+			if t == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// joinSchemaPath appends an escaped JSON Pointer reference token to a path.
+func joinSchemaPath(path, token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	token = strings.ReplaceAll(token, "/", "~1")
+	return strings.TrimSuffix(path, "/") + "/" + token
+}
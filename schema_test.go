@@ -0,0 +1,194 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testSchema1 = `{
+	"type": "object",
+	"required": ["username", "password", "role"],
+	"properties": {
+		"username": {"type": "string", "pattern": "^[a-z]+$"},
+		"password": {"type": "string", "minLength": 12, "maxLength": 64},
+		"role": {"enum": ["admin", "user"]},
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"db/hosts": {"type": "array", "minItems": 1, "items": {"type": "string"}}
+	}
+}`
+
+func TestValidateSchema(t *testing.T) {
+	testcases := []struct {
+		name      string
+		schema    string
+		secret    map[string]interface{}
+		shouldErr bool
+		err       error
+	}{
+		{
+			name:   "test valid secret",
+			schema: testSchema1,
+			secret: map[string]interface{}{
+				"username": "jsmith",
+				"password": "correct-horse-battery",
+				"role":     "admin",
+				"port":     float64(5432),
+				"db/hosts": []interface{}{"db1"},
+			},
+		},
+		{
+			name:   "test secret with violations",
+			schema: testSchema1,
+			secret: map[string]interface{}{
+				"username": "JSmith",
+				"password": "short",
+				"port":     float64(5432.5),
+				"db/hosts": []interface{}{float64(1)},
+			},
+			shouldErr: true,
+			err: fmt.Errorf("secret %q failed schema validation: %s", "foo", `/: missing required "role" property; `+
+				`/db~1hosts/0: must be of string type; /password: length must be at least 12; `+
+				`/port: must be of integer type; /username: must match "^[a-z]+$" pattern`),
+		},
+		{
+			name:   "test secret with unexpected properties",
+			schema: `{"type":"object","additionalProperties":false,"properties":{"value":{"type":"string"}}}`,
+			secret: map[string]interface{}{
+				"value": "foo",
+				"extra": "bar",
+			},
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q failed schema validation: %s", "foo", `/: unexpected "extra" property`),
+		},
+		{
+			name:   "test secret with value out of enum",
+			schema: `{"properties":{"usage":{"enum":["sign-verify","sign"]},"id":{"const":"0"}}}`,
+			secret: map[string]interface{}{
+				"usage": "verify-only-s3cr3t",
+				"id":    "1",
+			},
+			shouldErr: true,
+			err: fmt.Errorf("secret %q failed schema validation: %s", "foo",
+				"/id: must be equal to the constant value; /usage: must be one of the enumerated values"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			schema, err := parseSchema([]byte(tc.schema))
+			if err != nil {
+				t.Fatalf("unexpected schema parsing error: %v", err)
+			}
+			err = validateSchema(&Config{ID: "foo"}, schema, tc.secret)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("validateSchema() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	tmpDir := t.TempDir()
+	schemaFile := filepath.Join(tmpDir, "schema.json")
+	if err := ioutil.WriteFile(schemaFile, []byte(testSchema1), 0600); err != nil {
+		t.Fatalf("failed writing schema file: %v", err)
+	}
+
+	testcases := []struct {
+		name      string
+		cfg       *Config
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test inline schema",
+			cfg:  &Config{ID: "foo", Schema: []byte(testSchema1)},
+		},
+		{
+			name: "test schema file",
+			cfg:  &Config{ID: "foo", SchemaFile: schemaFile},
+		},
+		{
+			name:      "test missing schema file",
+			cfg:       &Config{ID: "foo", SchemaFile: filepath.Join(tmpDir, "foo.json")},
+			shouldErr: true,
+			err: fmt.Errorf("secret %q has unreadable schema file: open %s: no such file or directory",
+				"foo", filepath.Join(tmpDir, "foo.json")),
+		},
+		{
+			name:      "test schema with unsupported type",
+			cfg:       &Config{ID: "foo", Schema: []byte(`{"properties":{"foo":{"type":"float"}}}`)},
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has malformed schema: /foo: unsupported %q type", "foo", "float"),
+		},
+		{
+			name:      "test schema with invalid pattern",
+			cfg:       &Config{ID: "foo", Schema: []byte(`{"pattern":"["}`)},
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has malformed schema: /: invalid pattern: error parsing regexp: missing closing ]: `[`", "foo"),
+		},
+		{
+			name: "test schema with annotations",
+			cfg: &Config{ID: "foo", Schema: []byte(`{"$schema":"https://json-schema.org/draft/2020-12/schema",` +
+				`"title":"token","properties":{"foo":{"type":"string","description":"api key"}}}`)},
+		},
+		{
+			name:      "test schema with unsupported keyword",
+			cfg:       &Config{ID: "foo", Schema: []byte(`{"properties":{"foo":{"type":"string","format":"uri"}}}`)},
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has malformed schema: unsupported %q keyword", "foo", "format"),
+		},
+		{
+			name:      "test schema with unsupported combinator",
+			cfg:       &Config{ID: "foo", Schema: []byte(`{"oneOf":[{"type":"string"},{"type":"number"}]}`)},
+			shouldErr: true,
+			err:       fmt.Errorf("secret %q has malformed schema: unsupported %q keyword", "foo", "oneOf"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			schema, err := loadSchema(tc.cfg)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("loadSchema() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if schema == nil {
+				t.Fatalf("loadSchema() returned no schema")
+			}
+		})
+	}
+}
@@ -16,22 +16,110 @@ package secretsmanager
 
 import (
 	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
 )
 
-// GetSecret returns a secret in the form of a key-value map.
+// GetSecret returns a secret in the form of a key-value map. The map is a
+// copy of the cached secret, so the caller may modify it.
 func (p *Plugin) GetSecret(ctx context.Context) (map[string]interface{}, error) {
-	if p.secret != nil {
-		return p.secret, nil
+	secret, _, err := p.getSecret(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return p.client.GetSecret(ctx, p.Config.Path)
+	return copySecretValue(secret).(map[string]interface{}), nil
 }
 
-// GetSecretByKey returns a value of key in the secret key-value map.
+// GetSecretByKey returns a value of key in the secret key-value map. The key
+// may be a dotted path or a JSON Pointer referencing a nested value, which is
+// returned as a copy. When the key is not found, the secret may be refetched
+// according to the miss policy.
 func (p *Plugin) GetSecretByKey(ctx context.Context, key string) (interface{}, error) {
-	if p.secret != nil {
-		if v, exists := p.secret[key]; exists {
-			return v, nil
+	secret, fetched, err := p.getSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v, exists := lookupKey(secret, key); exists {
+		return copySecretValue(v), nil
+	}
+	if !fetched && p.shouldRefetch(key) {
+		// The refetched secret replaces the cached one, so that the key found
+		// in it is not refetched again. A failed refetch counts as a miss, so
+		// that it is not retried on every lookup.
+		snapshot, err := p.flights.do(flightReload, func() (*secretSnapshot, error) {
+			return p.reloadSecret(ctx, p.cache.load())
+		})
+		if err != nil {
+			p.recordMiss(key)
+			return nil, err
+		}
+		p.storeSecret(snapshot)
+		if v, exists := lookupKey(snapshot.secret, key); exists {
+			return copySecretValue(v), nil
+		}
+		p.recordMiss(key)
+	}
+	return nil, fmt.Errorf("key %q not found in %q secret", key, p.Config.ID)
+}
+
+// loadSecret retrieves the secret and checks that it holds the required keys.
+func (p *Plugin) loadSecret(ctx context.Context) (*secretSnapshot, error) {
+	snapshot, err := p.fetchSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSecret(&p.Config, snapshot.secret); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// fetchSecret retrieves the secret from AWS Secrets Manager.
+func (p *Plugin) fetchSecret(ctx context.Context) (*secretSnapshot, error) {
+	if p.Config.PathPrefix != "" {
+		return p.fetchDiscoveredSecrets(ctx)
+	}
+	return p.fetchPaths(ctx, p.Config.getPaths())
+}
+
+// fetchPaths retrieves the secrets at the paths and merges them into one.
+// The snapshot holds the IDs of the fetched versions keyed by path, and the
+// time the oldest of the secrets was fetched.
+func (p *Plugin) fetchPaths(ctx context.Context, paths []string) (*secretSnapshot, error) {
+	var secrets []*pathSecret
+	var fetchedAt time.Time
+	versions := make(map[string]string)
+	for _, path := range paths {
+		result, resultFetchedAt, err := p.getSecretValue(ctx, path)
+		if err != nil {
+			return nil, err
 		}
+		secret, err := decodeSecret(&p.Config, result)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &pathSecret{path: path, secret: secret})
+		versions[path] = aws.ToString(result.VersionId)
+		if fetchedAt.IsZero() || resultFetchedAt.Before(fetchedAt) {
+			fetchedAt = resultFetchedAt
+		}
+	}
+	secret, err := mergeSecrets(&p.Config, secrets)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSchema(&p.Config, p.schema, secret); err != nil {
+		return nil, err
+	}
+	secret, err = transformSecret(&p.Config, p.derived, secret)
+	if err != nil {
+		return nil, err
 	}
-	return p.client.GetSecretByKey(ctx, p.Config.Path, key)
+	return &secretSnapshot{
+		secret:    secret,
+		versions:  versions,
+		fetchedAt: fetchedAt,
+	}, nil
 }
@@ -51,6 +51,7 @@ func TestGetSecret(t *testing.T) {
 		name      string
 		cfg       string
 		secret    map[string]interface{}
+		response  map[string]interface{}
 		want      map[string]interface{}
 		shouldErr bool
 		err       error
@@ -61,6 +62,65 @@ func TestGetSecret(t *testing.T) {
 			secret: jsmith,
 			want:   jsmith,
 		},
+		{
+			name:   "test get secret with key aliases and projection",
+			cfg:    `{"id":"foo","path":"foo/bar","region":"us-east-1","aliases":{"login":"username"},"only":["login","password"]}`,
+			secret: jsmith,
+			want: map[string]interface{}{
+				"login":    jsmith["username"],
+				"password": jsmith["password"],
+			},
+		},
+		{
+			name: "test get text secret",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","format":"text"}`,
+			response: map[string]interface{}{
+				"SecretString": "b006d65b-c923-46a1-8da1-7d52558508fe",
+			},
+			want: map[string]interface{}{
+				"value": "b006d65b-c923-46a1-8da1-7d52558508fe",
+			},
+		},
+		{
+			name: "test get text secret with custom value key",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","format":"text","value_key":"hmac"}`,
+			response: map[string]interface{}{
+				"SecretString": "b006d65b-c923-46a1-8da1-7d52558508fe",
+			},
+			want: map[string]interface{}{
+				"hmac": "b006d65b-c923-46a1-8da1-7d52558508fe",
+			},
+		},
+		{
+			name: "test get binary secret",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","format":"binary","value_key":"keystore"}`,
+			response: map[string]interface{}{
+				"SecretBinary": "3q2+7w==",
+			},
+			want: map[string]interface{}{
+				"keystore": []byte{0xde, 0xad, 0xbe, 0xef},
+			},
+		},
+		{
+			name: "test get binary secret with hex encoding",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","format":"binary","encoding":"hex"}`,
+			response: map[string]interface{}{
+				"SecretBinary": "3q2+7w==",
+			},
+			want: map[string]interface{}{
+				"value": "deadbeef",
+			},
+		},
+		{
+			name: "test get binary secret with base64 encoding",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1","format":"binary","encoding":"base64"}`,
+			response: map[string]interface{}{
+				"SecretBinary": "3q2+7w==",
+			},
+			want: map[string]interface{}{
+				"value": "3q2+7w==",
+			},
+		},
 	}
 
 	for _, tc := range testcases {
@@ -72,11 +132,15 @@ func TestGetSecret(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected provisioning error: %v", err)
 			}
+			defer p.Cleanup()
 
 			var mockClinet aws.HTTPClient = smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
 				response := packMapToJSON(t, map[string]interface{}{
 					"SecretString": packMapToJSON(t, tc.secret),
 				})
+				if tc.response != nil {
+					response = packMapToJSON(t, tc.response)
+				}
 				return &http.Response{
 					StatusCode: 200,
 					Header:     http.Header{},
@@ -139,6 +203,28 @@ func TestGetSecretByKey(t *testing.T) {
 			key:    "password",
 			want:   jsmith["password"],
 		},
+		{
+			name: "test get nested secret by dotted path",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1"}`,
+			secret: map[string]interface{}{
+				"db": map[string]interface{}{
+					"primary": map[string]interface{}{"password": "foo"},
+				},
+			},
+			key:  "db.primary.password",
+			want: "foo",
+		},
+		{
+			name: "test get nested secret by json pointer",
+			cfg:  `{"id":"foo","path":"foo/bar","region":"us-east-1"}`,
+			secret: map[string]interface{}{
+				"db": map[string]interface{}{
+					"replicas": []interface{}{"foo", "bar"},
+				},
+			},
+			key:  "/db/replicas/1",
+			want: "bar",
+		},
 	}
 
 	for _, tc := range testcases {
@@ -150,6 +236,7 @@ func TestGetSecretByKey(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected provisioning error: %v", err)
 			}
+			defer p.Cleanup()
 
 			var mockClient aws.HTTPClient = smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
 				response := packMapToJSON(t, map[string]interface{}{
@@ -185,7 +272,7 @@ func TestGetSecretByKey(t *testing.T) {
 				t.Fatalf("unexpected success, want: %v", tc.err)
 			}
 
-			p.secret = nil
+			p.cache.clear()
 
 			got, err = p.GetSecretByKey(context.TODO(), tc.key)
 
@@ -0,0 +1,95 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"sort"
+)
+
+// validateTransform validates the key aliasing and projection settings of
+// the configuration.
+func validateTransform(cfg *Config) error {
+	for alias, source := range cfg.Aliases {
+		if alias == "" {
+			return fmt.Errorf("secret %q has empty key alias", cfg.ID)
+		}
+		if source == "" {
+			return fmt.Errorf("secret %q has empty source of %q key alias", cfg.ID, alias)
+		}
+	}
+	for _, k := range cfg.Only {
+		if k == "" {
+			return fmt.Errorf("secret %q has empty key in projection", cfg.ID)
+		}
+	}
+	return nil
+}
+
+// transformSecret renames the keys of the secret according to the key
+// aliases, renders the derived keys, and then removes the keys not listed in
+// the projection. A renamed nested key is removed from its object, and a
+// nested key in the projection keeps its path. The derived keys are never
+// removed.
+func transformSecret(cfg *Config, derived []*derivedKey, secret map[string]interface{}) (map[string]interface{}, error) {
+	if len(cfg.Aliases) == 0 && len(cfg.Only) == 0 && len(derived) == 0 {
+		return secret, nil
+	}
+
+	m := make(map[string]interface{})
+	for k, v := range secret {
+		m[k] = v
+	}
+
+	aliases := make([]string, 0, len(cfg.Aliases))
+	for alias := range cfg.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		source := cfg.Aliases[alias]
+		v, exists := lookupKey(secret, source)
+		if !exists {
+			return nil, fmt.Errorf("source key %q of %q key alias not found in %q secret", source, alias, cfg.ID)
+		}
+		m[alias] = v
+	}
+
+	for _, source := range cfg.Aliases {
+		if _, isAlias := cfg.Aliases[source]; isAlias {
+			continue
+		}
+		deleteKey(m, source)
+	}
+
+	values, err := deriveKeys(cfg, derived, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Only) > 0 {
+		projection := make(map[string]interface{})
+		for _, k := range cfg.Only {
+			projectKey(projection, m, k)
+		}
+		m = projection
+	}
+
+	for k, v := range values {
+		m[k] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTransformSecret(t *testing.T) {
+	secret := map[string]interface{}{
+		"user":     "jsmith",
+		"pass":     "foo",
+		"host":     "db.localdomain",
+		"db":       map[string]interface{}{"port": "5432", "name": "users"},
+		"password": "bar",
+	}
+	original := copySecretValue(secret)
+
+	testcases := []struct {
+		name      string
+		cfg       *Config
+		want      map[string]interface{}
+		shouldErr bool
+		err       error
+	}{
+		{
+			name: "test without aliases and projection",
+			cfg:  &Config{Path: "foo/bar"},
+			want: secret,
+		},
+		{
+			name: "test with aliases",
+			cfg: &Config{
+				Path:    "foo/bar",
+				Aliases: map[string]string{"username": "user", "port": "db.port"},
+			},
+			want: map[string]interface{}{
+				"username": "jsmith",
+				"pass":     "foo",
+				"host":     "db.localdomain",
+				"db":       map[string]interface{}{"name": "users"},
+				"port":     "5432",
+				"password": "bar",
+			},
+		},
+		{
+			name: "test with aliases of all nested keys",
+			cfg: &Config{
+				Path:    "foo/bar",
+				Aliases: map[string]string{"db_port": "db.port", "db_name": "/db/name"},
+			},
+			want: map[string]interface{}{
+				"user":     "jsmith",
+				"pass":     "foo",
+				"host":     "db.localdomain",
+				"db_port":  "5432",
+				"db_name":  "users",
+				"password": "bar",
+			},
+		},
+		{
+			name: "test with alias overriding existing key",
+			cfg: &Config{
+				Path:    "foo/bar",
+				Aliases: map[string]string{"password": "pass"},
+			},
+			want: map[string]interface{}{
+				"user":     "jsmith",
+				"host":     "db.localdomain",
+				"db":       map[string]interface{}{"port": "5432", "name": "users"},
+				"password": "foo",
+			},
+		},
+		{
+			name: "test with projection",
+			cfg: &Config{
+				Path: "foo/bar",
+				Only: []string{"user", "password", "foo"},
+			},
+			want: map[string]interface{}{
+				"user":     "jsmith",
+				"password": "bar",
+			},
+		},
+		{
+			name: "test with projection of nested key",
+			cfg: &Config{
+				Path: "foo/bar",
+				Only: []string{"user", "db.port", "db.host"},
+			},
+			want: map[string]interface{}{
+				"user": "jsmith",
+				"db":   map[string]interface{}{"port": "5432"},
+			},
+		},
+		{
+			name: "test with projection of json pointer",
+			cfg: &Config{
+				Path: "foo/bar",
+				Only: []string{"/db/name"},
+			},
+			want: map[string]interface{}{
+				"db": map[string]interface{}{"name": "users"},
+			},
+		},
+		{
+			name: "test with projection of nested key and its object",
+			cfg: &Config{
+				Path: "foo/bar",
+				Only: []string{"db", "db.port"},
+			},
+			want: map[string]interface{}{
+				"db": map[string]interface{}{"port": "5432", "name": "users"},
+			},
+		},
+		{
+			name: "test with aliases and projection",
+			cfg: &Config{
+				Path:    "foo/bar",
+				Aliases: map[string]string{"username": "user", "password": "pass"},
+				Only:    []string{"username", "password"},
+			},
+			want: map[string]interface{}{
+				"username": "jsmith",
+				"password": "foo",
+			},
+		},
+		{
+			name: "test with alias of missing key",
+			cfg: &Config{
+				ID:      "foo",
+				Path:    "foo/bar",
+				Aliases: map[string]string{"username": "login"},
+			},
+			shouldErr: true,
+			err:       fmt.Errorf("source key %q of %q key alias not found in %q secret", "login", "username", "foo"),
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := transformSecret(tc.cfg, nil, secret)
+			if diff := cmp.Diff(original, secret); diff != "" {
+				t.Fatalf("transformSecret() modified secret (-want +got):\n%s", diff)
+			}
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if diff := cmp.Diff(err.Error(), tc.err.Error()); diff != "" {
+					t.Fatalf("transformSecret() error mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("transformSecret() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secretsmanager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validateRequiredKeys validates the required keys of the configuration.
+func validateRequiredKeys(cfg *Config) error {
+	for _, k := range cfg.Required {
+		if k == "" {
+			return fmt.Errorf("secret %q has empty required key", cfg.ID)
+		}
+	}
+	return nil
+}
+
+// validateSecret checks that the secret holds the keys the configuration
+// requires. With a path prefix, each of the discovered secrets is checked.
+func validateSecret(cfg *Config, secret map[string]interface{}) error {
+	if cfg.PathPrefix != "" {
+		ids := make([]string, 0, len(secret))
+		for id := range secret {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			m, _ := secret[id].(map[string]interface{})
+			if err := checkRequiredKeys(cfg, id, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return checkRequiredKeys(cfg, cfg.ID, secret)
+}
+
+func checkRequiredKeys(cfg *Config, id string, secret map[string]interface{}) error {
+	var missing []string
+	for _, k := range cfg.Required {
+		if _, exists := lookupKey(secret, k); !exists {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("secret %q has missing required keys: %s", id, strings.Join(missing, ", "))
+	}
+	return nil
+}